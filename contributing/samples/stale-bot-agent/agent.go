@@ -1,23 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"sort"
 	"strings"
 	"time"
-
-	"google.golang.org/adk/tool"
-)
-
-var (
-	maintainersCache []string
 )
 
-var BOT_ALERT_SIGNATURE = "**Notification:** The author has updated the issue description"
+// Bot identity, set by InitConfig from the bot section of the config.
+var BOT_ALERT_SIGNATURE string
 
-var BOT_NAME = "adk-bot"
+var BOT_NAME string
 
 type TimelineEvent struct {
 	Type  string    `json:"type"`
@@ -47,32 +43,31 @@ type LabelTargetArgs struct {
 	LabelName   string `json:"label_name" description:"The specific name of the label"`
 }
 
-func getCachedMaintainers() ([]string, error) {
-	// if _MAINTAINERS_CACHE is not None: return it
-	if maintainersCache != nil {
-		return maintainersCache, nil
+func (r *Repository) getCachedMaintainers(ctx context.Context) ([]string, error) {
+	r.maintainersLock.Lock()
+	defer r.maintainersLock.Unlock()
+
+	if r.maintainersCache != nil {
+		return r.maintainersCache, nil
 	}
 
-	log.Println("Initializing Maintainers Cache...")
+	slog.InfoContext(ctx, "Initializing maintainers cache")
 
-	url := fmt.Sprintf("%s/repos/%s/%s/collaborators", GitHubBaseURL, Owner, Repo)
+	url := fmt.Sprintf("%s/repos/%s/%s/collaborators", GitHubBaseURL, r.Owner, r.Name)
 	params := map[string]interface{}{
 		"permission": "push",
 	}
 
 	// Uses your util-layer retry + backoff logic
-	data, err := GetRequest(url, params)
+	data, err := GetRequest(ctx, url, params)
 	if err != nil {
-		log.Printf("FATAL: Failed to verify repository maintainers. Error: %v", err)
+		slog.ErrorContext(ctx, "Failed to verify repository maintainers", "error", err)
 		return nil, fmt.Errorf("maintainer verification failed: %w", err)
 	}
 
 	rawList, ok := data.([]interface{})
 	if !ok {
-		log.Printf(
-			"Invalid API response format: Expected list, got %T",
-			data,
-		)
+		slog.ErrorContext(ctx, "Invalid collaborators response: expected a list", "type", fmt.Sprintf("%T", data))
 		return nil, fmt.Errorf("github API returned non-list data")
 	}
 
@@ -88,243 +83,159 @@ func getCachedMaintainers() ([]string, error) {
 		}
 	}
 
-	maintainersCache = maintainers
-	log.Printf("Cached %d maintainers.", len(maintainersCache))
+	r.maintainersCache = maintainers
+	slog.InfoContext(ctx, "Cached maintainers", "count", len(r.maintainersCache))
 
-	return maintainersCache, nil
+	return r.maintainersCache, nil
 }
 
-func FetchGraphQLData(itemNumber int) (map[string]any, error) {
+// FetchGraphQLData returns an issue with its history. Issues prefetched by
+// prefetchIssues are served from memory once; later calls hit the network.
+func (r *Repository) FetchGraphQLData(ctx context.Context, itemNumber int) (*GraphQLIssue, error) {
+	if issue, ok := r.takePrefetched(itemNumber); ok {
+		if err := r.completeIssueHistory(ctx, itemNumber, issue); err != nil {
+			return nil, err
+		}
+		return issue, nil
+	}
+
 	query := `
 query($owner: String!, $name: String!, $number: Int!, $commentLimit: Int!, $timelineLimit: Int!, $editLimit: Int!) {
   repository(owner: $owner, name: $name) {
-    issue(number: $number) {
-      author { login }
-      createdAt
-      labels(first: 20) { nodes { name } }
-
-      comments(last: $commentLimit) {
-        nodes {
-          author { login }
-          body
-          createdAt
-          lastEditedAt
-        }
-      }
-
-      userContentEdits(last: $editLimit) {
-        nodes {
-          editor { login }
-          editedAt
-        }
-      }
-
-      timelineItems(
-        itemTypes: [LABELED_EVENT, RENAMED_TITLE_EVENT, REOPENED_EVENT],
-        last: $timelineLimit
-      ) {
-        nodes {
-          __typename
-          ... on LabeledEvent {
-            createdAt
-            actor { login }
-            label { name }
-          }
-          ... on RenamedTitleEvent {
-            createdAt
-            actor { login }
-          }
-          ... on ReopenedEvent {
-            createdAt
-            actor { login }
-          }
-        }
-      }
-    }
+    issue(number: $number) { ...IssueFields }
   }
 }
-`
+` + issueFragment
 
 	variables := map[string]any{
-		"owner":         Owner,
-		"name":          Repo,
+		"owner":         r.Owner,
+		"name":          r.Name,
 		"number":        itemNumber,
 		"commentLimit":  GraphQLCommentLimit,
 		"editLimit":     GraphQLEditLimit,
 		"timelineLimit": GraphQLTimelineLimit,
 	}
 
-	payload := map[string]any{
-		"query":     query,
-		"variables": variables,
+	var data struct {
+		Repository *struct {
+			Issue *GraphQLIssue `json:"issue"`
+		} `json:"repository"`
 	}
 
-	respAny, err := PostRequest(GitHubBaseURL+"/graphql", payload)
-	if err != nil {
+	err := graphQLQuery(ctx, query, variables, &data)
+	if err := graphQLDataError(ctx, err, "number", itemNumber); err != nil {
 		return nil, err
 	}
 
-	resp, ok := respAny.(map[string]any)
-	if !ok {
-		return nil, fmt.Errorf("invalid GraphQL response format")
+	if data.Repository == nil {
+		return nil, notFoundError("repository %s", r.FullName())
 	}
-
-	if errs, ok := resp["errors"]; ok {
-		errList := errs.([]any)
-		firstErr := errList[0].(map[string]any)
-		return nil, fmt.Errorf("GraphQL Error: %v", firstErr["message"])
+	if data.Repository.Issue == nil {
+		return nil, notFoundError("issue #%d", itemNumber)
 	}
 
-	data := resp["data"].(map[string]any)
-	repo := data["repository"].(map[string]any)
-	issue := repo["issue"]
-
-	if issue == nil {
-		return nil, fmt.Errorf("Issue #%d not found.", itemNumber)
+	issue := data.Repository.Issue
+	if err := r.completeIssueHistory(ctx, itemNumber, issue); err != nil {
+		return nil, err
 	}
-
-	return issue.(map[string]any), nil
+	return issue, nil
 }
 
-func buildHistoryTimeline(data map[string]any) ([]TimelineEvent, []time.Time, *time.Time) {
-	issueAuthor := ""
-	if author, ok := data["author"].(map[string]any); ok {
-		issueAuthor, _ = author["login"].(string)
-	}
+func buildHistoryTimeline(issue *GraphQLIssue, staleLabelName string) ([]TimelineEvent, []time.Time, *time.Time) {
+	issueAuthor := LoginOf(issue.Author)
 
 	var history []TimelineEvent
 	var labelEvents []time.Time
 	var lastBotAlertTime *time.Time
 
-	parseTime := func(val any) time.Time {
-		s, _ := val.(string)
-		t, _ := time.Parse(time.RFC3339, s)
-		return t
-	}
-
 	isBot := func(actor string) bool {
 		return actor == "" || strings.HasSuffix(actor, "[bot]") || actor == BOT_NAME
 	}
 
 	// 1. Baseline: Issue Creation
-	createdAt := parseTime(data["createdAt"])
 	history = append(history, TimelineEvent{
 		Type:  "created",
 		Actor: issueAuthor,
-		Time:  createdAt,
+		Time:  issue.CreatedAt,
 		Data:  nil,
 	})
 
 	// 2. Process Comments
-	if comments, ok := data["comments"].(map[string]any); ok {
-		if nodes, ok := comments["nodes"].([]any); ok {
-			for _, node := range nodes {
-				c, ok := node.(map[string]any)
-				if !ok || c == nil {
-					continue
-				}
-
-				actor := ""
-				if a, ok := c["author"].(map[string]any); ok {
-					actor, _ = a["login"].(string)
-				}
-
-				cBody, _ := c["body"].(string)
-				cTime := parseTime(c["createdAt"])
-
-				// Track bot alerts for spam prevention
-				if strings.Contains(cBody, BOT_ALERT_SIGNATURE) {
-					if lastBotAlertTime == nil || cTime.After(*lastBotAlertTime) {
-						tempTime := cTime
-						lastBotAlertTime = &tempTime
-					}
-					continue
-				}
-
-				if !isBot(actor) {
-					// Use edit time if available, otherwise creation time
-					actualTime := cTime
-					if eTimeStr, ok := c["lastEditedAt"].(string); ok && eTimeStr != "" {
-						actualTime = parseTime(eTimeStr)
-					}
-
-					history = append(history, TimelineEvent{
-						Type:  "commented",
-						Actor: actor,
-						Time:  actualTime,
-						Data:  cBody,
-					})
-				}
+	for _, c := range issue.Comments.Nodes {
+		if c == nil {
+			continue
+		}
+
+		actor := LoginOf(c.Author)
+
+		// Track bot alerts for spam prevention
+		if strings.Contains(c.Body, BOT_ALERT_SIGNATURE) {
+			if lastBotAlertTime == nil || c.CreatedAt.After(*lastBotAlertTime) {
+				tempTime := c.CreatedAt
+				lastBotAlertTime = &tempTime
+			}
+			continue
+		}
+
+		if !isBot(actor) {
+			// Use edit time if available, otherwise creation time
+			actualTime := c.CreatedAt
+			if c.LastEditedAt != nil {
+				actualTime = *c.LastEditedAt
 			}
+
+			history = append(history, TimelineEvent{
+				Type:  "commented",
+				Actor: actor,
+				Time:  actualTime,
+				Data:  c.Body,
+			})
 		}
 	}
 
 	// 3. Process Body Edits ("Ghost Edits")
-	if edits, ok := data["userContentEdits"].(map[string]any); ok {
-		if nodes, ok := edits["nodes"].([]any); ok {
-			for _, node := range nodes {
-				e, ok := node.(map[string]any)
-				if !ok || e == nil {
-					continue
-				}
-
-				actor := ""
-				if ed, ok := e["editor"].(map[string]any); ok {
-					actor, _ = ed["login"].(string)
-				}
-
-				if !isBot(actor) {
-					history = append(history, TimelineEvent{
-						Type:  "edited_description",
-						Actor: actor,
-						Time:  parseTime(e["editedAt"]),
-						Data:  nil,
-					})
-				}
-			}
+	for _, e := range issue.UserContentEdits.Nodes {
+		if e == nil {
+			continue
+		}
+
+		actor := LoginOf(e.Editor)
+		if !isBot(actor) {
+			history = append(history, TimelineEvent{
+				Type:  "edited_description",
+				Actor: actor,
+				Time:  e.EditedAt,
+				Data:  nil,
+			})
 		}
 	}
 
 	// 4. Process Timeline Events
-	if timeline, ok := data["timelineItems"].(map[string]any); ok {
-		if nodes, ok := timeline["nodes"].([]any); ok {
-			for _, node := range nodes {
-				t, ok := node.(map[string]any)
-				if !ok || t == nil {
-					continue
-				}
-
-				etype, _ := t["__typename"].(string)
-				actor := ""
-				if a, ok := t["actor"].(map[string]any); ok {
-					actor, _ = a["login"].(string)
-				}
-				timeVal := parseTime(t["createdAt"])
-
-				if etype == "LabeledEvent" {
-					labelName := ""
-					if lbl, ok := t["label"].(map[string]any); ok {
-						labelName, _ = lbl["name"].(string)
-					}
-					if labelName == STALE_LABEL_NAME {
-						labelEvents = append(labelEvents, timeVal)
-					}
-					continue
-				}
-
-				if !isBot(actor) {
-					prettyType := "reopened"
-					if etype == "RenamedTitleEvent" {
-						prettyType = "renamed_title"
-					}
-					history = append(history, TimelineEvent{
-						Type:  prettyType,
-						Actor: actor,
-						Time:  timeVal,
-						Data:  nil,
-					})
-				}
+	for _, t := range issue.TimelineItems.Nodes {
+		if t == nil {
+			continue
+		}
+
+		actor := LoginOf(t.Actor)
+
+		if t.Typename == "LabeledEvent" {
+			if t.Label != nil && t.Label.Name == staleLabelName {
+				labelEvents = append(labelEvents, t.CreatedAt)
+			}
+			continue
+		}
+
+		if !isBot(actor) {
+			prettyType := "reopened"
+			if t.Typename == "RenamedTitleEvent" {
+				prettyType = "renamed_title"
 			}
+			history = append(history, TimelineEvent{
+				Type:  prettyType,
+				Actor: actor,
+				Time:  t.CreatedAt,
+				Data:  nil,
+			})
 		}
 	}
 
@@ -411,199 +322,255 @@ func errorResponse(msg string) map[string]any {
 	}
 }
 
-func addLabelToIssue(ctx tool.Context, args LabelTargetArgs) (ToolResult, error) {
+func (r *Repository) addLabelToIssue(ctx context.Context, args LabelTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
 	url := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/labels",
 		GitHubBaseURL,
-		Owner,
-		Repo,
+		r.Owner,
+		r.Name,
 		args.IssueNumber,
 	)
 
 	payload := []string{args.LabelName}
 
-	_, err := PostRequest(url, payload)
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "add_label_to_issue",
+		Method:      "POST",
+		URL:         url,
+		Payload:     payload,
+	})
 	if err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: err.Error(),
-		}, err
+		return toolFailure("adding label", err)
 	}
 
-	return ToolResult{
-		Status: "success",
-	}, nil
+	return successResult(), nil
 }
 
-func removeLabelFromIssue(ctx tool.Context, args LabelTargetArgs) (ToolResult, error) {
+func (r *Repository) removeLabelFromIssue(ctx context.Context, args LabelTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
 	url := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/labels/%s",
 		GitHubBaseURL,
-		Owner,
-		Repo,
+		r.Owner,
+		r.Name,
 		args.IssueNumber,
 		args.LabelName,
 	)
 
-	_, err := DeleteRequest(url)
-	if err != nil {
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "remove_label_from_issue",
+		Method:      "DELETE",
+		URL:         url,
+	})
+	if IsNotFound(err) {
 		return ToolResult{
 			Status:  "failure",
-			Message: fmt.Sprintf("error removing label: %v", err),
-		}, err
+			Message: fmt.Sprintf("label %q is not present on issue #%d, nothing was removed", args.LabelName, args.IssueNumber),
+		}, nil
+	}
+	if err != nil {
+		return toolFailure("removing label", err)
 	}
 
-	return ToolResult{
-		Status: "success",
-	}, nil
+	return successResult(), nil
 }
 
-func addStaleLabelAndComment(ctx tool.Context, args IssueTargetArgs) (ToolResult, error) {
-	staleDaysStr := formatDays(STALE_HOURS_THRESHOLD)
-	closeDaysStr := formatDays(CLOSE_HOURS_AFTER_STALE_THRESHOLD)
+func (r *Repository) addStaleLabelAndComment(ctx context.Context, args IssueTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
 
-	comment := fmt.Sprintf(
-		"This issue has been automatically marked as stale because it has not"+
-			" had recent activity for %s days after a maintainer"+
-			" requested clarification. It will be closed if no further activity"+
-			" occurs within %s days.",
-		staleDaysStr, closeDaysStr,
-	)
+	comment := formatPrompt(StaleCommentTemplate, map[string]string{
+		"stale_days": formatDays(r.StaleHoursThreshold),
+		"close_days": formatDays(r.CloseHoursAfterStaleThreshold),
+	})
 
 	// 1. Post comment
 	commentURL := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/comments",
-		GitHubBaseURL, Owner, Repo, args.IssueNumber,
+		GitHubBaseURL, r.Owner, r.Name, args.IssueNumber,
 	)
 
-	if _, err := PostRequest(commentURL, map[string]string{"body": comment}); err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: fmt.Sprintf("error posting stale comment: %v", err),
-		}, err
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "add_stale_label_and_comment",
+		Method:      "POST",
+		URL:         commentURL,
+		Payload:     map[string]string{"body": comment},
+		Comment:     comment,
+	})
+	if err != nil {
+		return toolFailure("posting stale comment", err)
 	}
 
 	// 2. Add label
 	labelURL := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/labels",
-		GitHubBaseURL, Owner, Repo, args.IssueNumber,
+		GitHubBaseURL, r.Owner, r.Name, args.IssueNumber,
 	)
 
-	if _, err := PostRequest(labelURL, []string{STALE_LABEL_NAME}); err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: fmt.Sprintf("error adding stale label: %v", err),
-		}, err
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "add_stale_label_and_comment",
+		Method:      "POST",
+		URL:         labelURL,
+		Payload:     []string{r.StaleLabelName},
+	})
+	if err != nil {
+		return toolFailure("adding stale label", err)
 	}
 
-	return ToolResult{
-		Status: "success",
-	}, nil
+	return successResult(), nil
 }
 
-func alertMaintainerOfEdit(ctx tool.Context, args IssueTargetArgs) (ToolResult, error) {
-	comment := fmt.Sprintf("%s. Maintainers, please review.", BOT_ALERT_SIGNATURE)
+func (r *Repository) alertMaintainerOfEdit(ctx context.Context, args IssueTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
+	comment := formatPrompt(AlertCommentTemplate, map[string]string{
+		"alert_signature": BOT_ALERT_SIGNATURE,
+	})
 
 	url := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/comments",
-		GitHubBaseURL, Owner, Repo, args.IssueNumber,
+		GitHubBaseURL, r.Owner, r.Name, args.IssueNumber,
 	)
 
-	if _, err := PostRequest(url, map[string]string{"body": comment}); err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: fmt.Sprintf("error posting alert: %v", err),
-		}, err
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "alert_maintainer_of_edit",
+		Method:      "POST",
+		URL:         url,
+		Payload:     map[string]string{"body": comment},
+		Comment:     comment,
+	})
+	if err != nil {
+		return toolFailure("posting alert", err)
 	}
 
-	return ToolResult{
-		Status: "success",
-	}, nil
+	return successResult(), nil
 }
 
-func closeAsStale(ctx tool.Context, args IssueTargetArgs) (ToolResult, error) {
-	daysStr := formatDays(CLOSE_HOURS_AFTER_STALE_THRESHOLD)
+func (r *Repository) closeAsStale(ctx context.Context, args IssueTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
 
-	comment := fmt.Sprintf(
-		"This has been automatically closed because it has been marked as stale"+
-			" for over %s days.",
-		daysStr,
-	)
+	comment := formatPrompt(CloseCommentTemplate, map[string]string{
+		"close_days": formatDays(r.CloseHoursAfterStaleThreshold),
+	})
 
 	// 1. Post comment
 	commentURL := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d/comments",
-		GitHubBaseURL, Owner, Repo, args.IssueNumber,
+		GitHubBaseURL, r.Owner, r.Name, args.IssueNumber,
 	)
 
-	if _, err := PostRequest(commentURL, map[string]string{"body": comment}); err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: fmt.Sprintf("error posting close comment: %v", err),
-		}, err
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "close_as_stale",
+		Method:      "POST",
+		URL:         commentURL,
+		Payload:     map[string]string{"body": comment},
+		Comment:     comment,
+	})
+	if err != nil {
+		return toolFailure("posting close comment", err)
 	}
 
 	// 2. Close issue
 	issueURL := fmt.Sprintf(
 		"%s/repos/%s/%s/issues/%d",
-		GitHubBaseURL, Owner, Repo, args.IssueNumber,
+		GitHubBaseURL, r.Owner, r.Name, args.IssueNumber,
 	)
 
-	if _, err := PatchRequest(issueURL, map[string]string{"state": "closed"}); err != nil {
-		return ToolResult{
-			Status:  "failure",
-			Message: fmt.Sprintf("error closing issue: %v", err),
-		}, err
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.IssueNumber,
+		Tool:        "close_as_stale",
+		Method:      "PATCH",
+		URL:         issueURL,
+		Payload:     map[string]string{"state": "closed"},
+	})
+	if err != nil {
+		return toolFailure("closing issue", err)
 	}
 
-	return ToolResult{
-		Status: "success",
-	}, nil
+	return successResult(), nil
 }
 
-// getIssueState orchestrates the fetching and analysis of an issue.
-func getIssueState(ctx tool.Context, args IssueTargetArgs) (map[string]any, error) {
-	itemNumber := args.IssueNumber
+// IssueAnalysis is the derived state of an issue that drives the stale
+// decision tree. getIssueState hands it to the model as a map, and the rule
+// engine evaluates it directly.
+type IssueAnalysis struct {
+	Repository            *Repository
+	IssueNumber           int
+	State                 IssueState
+	IssueAuthor           string
+	Labels                []string
+	Maintainers           []string
+	IsStale               bool
+	DaysSinceActivity     float64
+	DaysSinceStaleLabel   float64
+	MaintainerAlertNeeded bool
+	HistoryTruncated      bool
+}
 
-	maintainers, err := getCachedMaintainers()
+// analyzeIssue fetches an issue and replays its history into an IssueAnalysis.
+func (r *Repository) analyzeIssue(ctx context.Context, itemNumber int) (*IssueAnalysis, error) {
+	maintainers, err := r.getCachedMaintainers(ctx)
 	if err != nil {
-		return errorResponse(fmt.Sprintf("error getting cached maintainers: %v", err)), nil
+		return nil, fmt.Errorf("error getting cached maintainers: %w", err)
 	}
 
-	rawData, err := FetchGraphQLData(itemNumber)
+	issue, err := r.FetchGraphQLData(ctx, itemNumber)
 	if err != nil {
-		return errorResponse(fmt.Sprintf("network error: %v", err)), nil
-	}
-
-	// Extract author
-	issueAuthor := ""
-	if author, ok := rawData["author"].(map[string]any); ok {
-		issueAuthor, _ = author["login"].(string)
+		return nil, fmt.Errorf("network error: %w", err)
 	}
+	return r.analyzeIssueData(itemNumber, issue, maintainers), nil
+}
 
-	// Extract labels
-	var labelsList []string
-	if labels, ok := rawData["labels"].(map[string]any); ok {
-		if nodes, ok := labels["nodes"].([]any); ok {
-			for _, n := range nodes {
-				if node, ok := n.(map[string]any); ok {
-					if name, ok := node["name"].(string); ok {
-						labelsList = append(labelsList, name)
-					}
-				}
-			}
-		}
-	}
+// analyzeIssueData replays a fetched issue's history into an IssueAnalysis
+// as of clock().
+func (r *Repository) analyzeIssueData(itemNumber int, issue *GraphQLIssue, maintainers []string) *IssueAnalysis {
+	issueAuthor := LoginOf(issue.Author)
+	labelsList := issue.LabelNames()
 
-	history, labelEvents, lastBotAlertTime := buildHistoryTimeline(rawData)
+	history, labelEvents, lastBotAlertTime := buildHistoryTimeline(issue, r.StaleLabelName)
 	state := replayHistoryToFindState(history, maintainers, issueAuthor)
 
-	now := time.Now().UTC()
+	now := clock().UTC()
 	daysSinceActivity := now.Sub(state.LastActivityTime).Hours() / 24.0
 
 	isStale := false
 	for _, l := range labelsList {
-		if l == STALE_LABEL_NAME {
+		if l == r.StaleLabelName {
 			isStale = true
 			break
 		}
@@ -629,20 +596,49 @@ func getIssueState(ctx tool.Context, args IssueTargetArgs) (map[string]any, erro
 		}
 	}
 
+	return &IssueAnalysis{
+		Repository:            r,
+		IssueNumber:           itemNumber,
+		State:                 state,
+		IssueAuthor:           issueAuthor,
+		Labels:                labelsList,
+		Maintainers:           maintainers,
+		IsStale:               isStale,
+		DaysSinceActivity:     daysSinceActivity,
+		DaysSinceStaleLabel:   daysSinceStaleLabel,
+		MaintainerAlertNeeded: maintainerAlertNeeded,
+		HistoryTruncated:      issue.HistoryTruncated,
+	}
+}
+
+// toMap renders the analysis in the shape returned by the get_issue_state tool.
+func (a *IssueAnalysis) toMap() map[string]any {
 	return map[string]any{
 		"status":                  "success",
-		"last_action_role":        state.LastActionRole,
-		"last_action_type":        state.LastActionType,
-		"last_actor_name":         state.LastActorName,
-		"maintainer_alert_needed": maintainerAlertNeeded,
-		"is_stale":                isStale,
-		"days_since_activity":     daysSinceActivity,
-		"days_since_stale_label":  daysSinceStaleLabel,
-		"last_comment_text":       state.LastCommentText,
-		"current_labels":          labelsList,
-		"stale_threshold_days":    STALE_HOURS_THRESHOLD / 24.0,
-		"close_threshold_days":    CLOSE_HOURS_AFTER_STALE_THRESHOLD / 24.0,
-		"maintainers":             maintainers,
-		"issue_author":            issueAuthor,
-	}, nil
+		"last_action_role":        a.State.LastActionRole,
+		"last_action_type":        a.State.LastActionType,
+		"last_actor_name":         a.State.LastActorName,
+		"maintainer_alert_needed": a.MaintainerAlertNeeded,
+		"is_stale":                a.IsStale,
+		"days_since_activity":     a.DaysSinceActivity,
+		"days_since_stale_label":  a.DaysSinceStaleLabel,
+		"last_comment_text":       a.State.LastCommentText,
+		"current_labels":          a.Labels,
+		"stale_threshold_days":    a.Repository.staleThresholdDays(),
+		"close_threshold_days":    a.Repository.closeThresholdDays(),
+		"maintainers":             a.Maintainers,
+		"issue_author":            a.IssueAuthor,
+		"history_truncated":       a.HistoryTruncated,
+	}
+}
+
+// getIssueState orchestrates the fetching and analysis of an issue.
+func (r *Repository) getIssueState(ctx context.Context, args IssueTargetArgs) (map[string]any, error) {
+	analysis, err := r.analyzeIssue(ctx, args.IssueNumber)
+	if err != nil {
+		return errorResponse(err.Error()), nil
+	}
+	state := analysis.toMap()
+	auditFrom(ctx).setState(state)
+	return state, nil
 }
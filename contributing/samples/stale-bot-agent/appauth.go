@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// When a GitHub App is configured, every request is authenticated with an
+// installation access token instead of the static GITHUB_TOKEN:
+//   - a short-lived JWT, signed with the app's private key, authenticates
+//     as the app itself;
+//   - the JWT finds the installation that covers a repository and exchanges
+//     it for an installation token (valid for one hour);
+//   - tokens are cached per installation and refreshed shortly before they
+//     expire, so long runs never send an expired token.
+
+const (
+	// JWTs may live at most 10 minutes; stay below to absorb clock drift.
+	appJWTLifetime = 9 * time.Minute
+	// Refresh installation tokens this long before GitHub expires them.
+	installationTokenRefresh = 5 * time.Minute
+)
+
+// gitHubApp is set by InitConfig when app credentials are configured.
+var gitHubApp *GitHubApp
+
+// GitHubApp holds the app credentials and the per-repository installation
+// and token caches.
+type GitHubApp struct {
+	ID  int
+	key *rsa.PrivateKey
+
+	// lock guards the maps below and is never held across a request.
+	lock          sync.Mutex
+	installations map[string]int64 // "owner/name" -> installation ID
+	tokens        map[int64]installationToken
+	// inflight serializes lookups per repository and token mints per
+	// installation, so concurrent callers share one request instead of
+	// each making it, without blocking other installations.
+	inflight map[string]*sync.Mutex
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewGitHubApp parses a PEM encoded private key (PKCS#1 as downloaded from
+// GitHub, or PKCS#8).
+func NewGitHubApp(id int, privateKeyPEM []byte) (*GitHubApp, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+
+	return &GitHubApp{
+		ID:            id,
+		key:           key,
+		installations: map[string]int64{},
+		tokens:        map[int64]installationToken{},
+		inflight:      map[string]*sync.Mutex{},
+	}, nil
+}
+
+// JWT returns a freshly signed RS256 token that authenticates as the app.
+func (a *GitHubApp) JWT() (string, error) {
+	now := time.Now()
+	header := map[string]any{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]any{
+		// Backdated to tolerate clock drift between us and GitHub.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": fmt.Sprint(a.ID),
+	}
+
+	var parts []string
+	for _, v := range []any{header, claims} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(b))
+	}
+
+	signingInput := strings.Join(parts, ".")
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing app JWT: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// appRequest sends a request authenticated as the app and decodes the
+// response into out.
+func (a *GitHubApp) appRequest(ctx context.Context, method, url string, out any) error {
+	jwt, err := a.JWT()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Slug returns the app's slug, which is also the login its comments are
+// posted under.
+func (a *GitHubApp) Slug(ctx context.Context) (string, error) {
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if err := a.appRequest(ctx, http.MethodGet, GitHubBaseURL+"/app", &app); err != nil {
+		return "", fmt.Errorf("fetching app: %w", err)
+	}
+	return app.Slug, nil
+}
+
+// keyLock returns the mutex that serializes requests for key.
+func (a *GitHubApp) keyLock(key string) *sync.Mutex {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	m, ok := a.inflight[key]
+	if !ok {
+		m = &sync.Mutex{}
+		a.inflight[key] = m
+	}
+	return m
+}
+
+// cachedInstallation returns the cached installation ID of a repository.
+func (a *GitHubApp) cachedInstallation(fullName string) (int64, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	id, ok := a.installations[fullName]
+	return id, ok
+}
+
+// cachedToken returns the installation's token if it is not about to expire.
+func (a *GitHubApp) cachedToken(id int64) (string, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	tok, ok := a.tokens[id]
+	if !ok || time.Until(tok.ExpiresAt) <= installationTokenRefresh {
+		return "", false
+	}
+	return tok.Token, true
+}
+
+// installationID discovers, and caches, the installation covering a
+// repository.
+func (a *GitHubApp) installationID(ctx context.Context, fullName string) (int64, error) {
+	if id, ok := a.cachedInstallation(fullName); ok {
+		return id, nil
+	}
+
+	flight := a.keyLock("repository " + fullName)
+	flight.Lock()
+	defer flight.Unlock()
+	if id, ok := a.cachedInstallation(fullName); ok {
+		return id, nil
+	}
+
+	var inst struct {
+		ID int64 `json:"id"`
+	}
+	err := a.appRequest(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s/installation", GitHubBaseURL, fullName), &inst)
+	if err != nil {
+		if IsNotFound(err) {
+			return 0, fmt.Errorf("the app is not installed on %s", fullName)
+		}
+		return 0, fmt.Errorf("finding installation for %s: %w", fullName, err)
+	}
+
+	a.lock.Lock()
+	a.installations[fullName] = inst.ID
+	a.lock.Unlock()
+	return inst.ID, nil
+}
+
+// InstallationToken returns a valid installation token for a repository,
+// minting a new one when the cached token is missing or about to expire.
+// Only callers needing the same installation wait for each other.
+func (a *GitHubApp) InstallationToken(ctx context.Context, fullName string) (string, error) {
+	if fullName == "" {
+		return "", errors.New("no repository to pick an app installation for")
+	}
+
+	id, err := a.installationID(ctx, fullName)
+	if err != nil {
+		return "", err
+	}
+	if tok, ok := a.cachedToken(id); ok {
+		return tok, nil
+	}
+
+	flight := a.keyLock(fmt.Sprintf("installation %d", id))
+	flight.Lock()
+	defer flight.Unlock()
+	if tok, ok := a.cachedToken(id); ok {
+		return tok, nil
+	}
+
+	var tok installationToken
+	err = a.appRequest(ctx, http.MethodPost, fmt.Sprintf("%s/app/installations/%d/access_tokens", GitHubBaseURL, id), &tok)
+	if err != nil {
+		return "", fmt.Errorf("creating installation token for %s: %w", fullName, err)
+	}
+
+	registerSecret(tok.Token)
+	a.lock.Lock()
+	a.tokens[id] = tok
+	a.lock.Unlock()
+	return tok.Token, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAppServer serves installation lookups and token mints, and counts the
+// mints. Repositories named "slow..." belong to installation 2, whose mint
+// takes slowMint; all others to installation 1.
+type fakeAppServer struct {
+	mints    atomic.Int32
+	slowMint time.Duration
+}
+
+func (f *fakeAppServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/installation"):
+		id := 1
+		if strings.Contains(r.URL.Path, "/slow") {
+			id = 2
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": id})
+	case strings.HasSuffix(r.URL.Path, "/access_tokens"):
+		f.mints.Add(1)
+		if strings.Contains(r.URL.Path, "/installations/2/") {
+			select {
+			case <-time.After(f.slowMint):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      "tok-" + r.URL.Path,
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestApp(t *testing.T, srv *fakeAppServer) *GitHubApp {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	cfg := defaultFileConfig()
+	cfg.GitHub.APIURL = ts.URL
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	app, err := NewGitHubApp(1, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestInstallationTokenSharedMint(t *testing.T) {
+	srv := &fakeAppServer{}
+	app := newTestApp(t, srv)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := app.InstallationToken(context.Background(), "owner/repo"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := srv.mints.Load(); n != 1 {
+		t.Errorf("concurrent callers minted %d tokens, want 1", n)
+	}
+}
+
+func TestInstallationTokenSlowMintIsolated(t *testing.T) {
+	srv := &fakeAppServer{slowMint: 5 * time.Second}
+	app := newTestApp(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.InstallationToken(ctx, "owner/slow")
+	for srv.mints.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	tok, err := app.InstallationToken(context.Background(), "owner/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("tok-/app/installations/%d/access_tokens", 1); tok != want {
+		t.Errorf("token = %q, want %q", tok, want)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("token for another installation took %s behind a slow mint", elapsed)
+	}
+}
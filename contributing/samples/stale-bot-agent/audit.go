@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every processed issue and pull request produces one AuditRecord: the
+// state the decision was based on, the full final report, every tool call
+// with its arguments and result, and timings. Records are appended to the
+// audit log keyed by RunID, so "why did the bot close #123?" can be answered
+// long after the run's console output is gone.
+
+// RunID identifies this run in the audit log.
+var RunID string
+
+// auditSink receives the records; nil disables the audit log.
+var auditSink AuditSink
+
+// AuditSink stores audit records. Implementations must be safe for
+// concurrent use and must only ever append.
+type AuditSink interface {
+	Write(rec AuditRecord) error
+	Close() error
+}
+
+// AuditRecord is everything the bot saw and did for one issue or pull
+// request.
+type AuditRecord struct {
+	RunID      string    `json:"run_id"`
+	Repository string    `json:"repository"`
+	Number     int       `json:"number"`
+	Kind       string    `json:"kind"` // "issue" or "pull_request"
+	Engine     string    `json:"engine"`
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	APICalls   int       `json:"api_calls"`
+
+	// State is the snapshot the decision was based on, as returned by
+	// get_issue_state for issues.
+	State     any             `json:"state,omitempty"`
+	Verdict   string          `json:"verdict,omitempty"`
+	Report    string          `json:"report,omitempty"`
+	ToolCalls []AuditToolCall `json:"tool_calls"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// AuditToolCall is one tool invocation, by the model or the rule engine.
+type AuditToolCall struct {
+	Tool   string    `json:"tool"`
+	At     time.Time `json:"at"`
+	Args   any       `json:"args"`
+	Result any       `json:"result,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// newRunID uses the GitHub Actions run when available, so audit records can
+// be matched to the workflow run, and a timestamp otherwise.
+func newRunID() string {
+	if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+		return fmt.Sprintf("gh-%s-%s", id, os.Getenv("GITHUB_RUN_ATTEMPT"))
+	}
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+var reportVerdictPattern = regexp.MustCompile(`Analysis for (?:Issue|Pull Request) #\d+:\s*(ACTIVE|PENDING|STALE)\b`)
+
+// reportVerdict extracts the verdict from a report that follows the prompt's
+// "Analysis for Issue #N: VERDICT." format, or returns "".
+func reportVerdict(report string) string {
+	if m := reportVerdictPattern.FindStringSubmatch(report); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// ---------------- JSON Lines Sink ----------------
+
+// jsonlSink appends one JSON object per line to a file.
+type jsonlSink struct {
+	lock sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewJSONLAuditSink opens path for appending, creating it if needed.
+func NewJSONLAuditSink(path string) (AuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &jsonlSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *jsonlSink) Write(rec AuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.enc.Encode(rec)
+}
+
+func (s *jsonlSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// ---------------- Per-Issue Recorder ----------------
+
+// issueAudit collects the record of one issue while it is processed. Tools
+// reach it through the context; all methods are no-ops on nil.
+type issueAudit struct {
+	lock  sync.Mutex
+	rec   AuditRecord
+	start time.Time
+	// GitHub requests made with this issue's context, counted apart from
+	// the run total that every worker adds to.
+	apiCalls atomic.Int64
+}
+
+type issueAuditKey struct{}
+
+// startIssueAudit begins the record for one issue or pull request and
+// attaches it to ctx.
+func startIssueAudit(ctx context.Context, repo *Repository, kind string, number int) (context.Context, *issueAudit) {
+	a := &issueAudit{
+		start: time.Now(),
+		rec: AuditRecord{
+			RunID:      RunID,
+			Repository: repo.FullName(),
+			Number:     number,
+			Kind:       kind,
+			Engine:     DecisionEngine,
+			DryRun:     DryRun,
+			StartedAt:  time.Now().UTC(),
+			ToolCalls:  []AuditToolCall{},
+		},
+	}
+	if kind == "pull_request" {
+		a.rec.Engine = "rules"
+	}
+	return context.WithValue(ctx, issueAuditKey{}, a), a
+}
+
+// auditFrom returns the recorder attached to ctx, or nil.
+func auditFrom(ctx context.Context) *issueAudit {
+	a, _ := ctx.Value(issueAuditKey{}).(*issueAudit)
+	return a
+}
+
+func (a *issueAudit) setState(state any) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	a.rec.State = state
+	a.lock.Unlock()
+}
+
+func (a *issueAudit) setDecision(verdict, report string) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	a.rec.Verdict = verdict
+	a.rec.Report = report
+	a.lock.Unlock()
+}
+
+func (a *issueAudit) recordToolCall(tool string, args, result any, err error) {
+	if a == nil {
+		return
+	}
+	call := AuditToolCall{Tool: tool, At: time.Now().UTC(), Args: args, Result: result}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	a.lock.Lock()
+	a.rec.ToolCalls = append(a.rec.ToolCalls, call)
+	a.lock.Unlock()
+}
+
+func (a *issueAudit) countAPICall() {
+	if a == nil {
+		return
+	}
+	a.apiCalls.Add(1)
+}
+
+func (a *issueAudit) fail(err error) {
+	if a == nil || err == nil {
+		return
+	}
+	a.lock.Lock()
+	if a.rec.Error == "" {
+		a.rec.Error = err.Error()
+	}
+	a.lock.Unlock()
+}
+
+// finish completes the record, appends it to the audit log, keeps it for
+// the run report and records its latency and verdict metrics.
+func (a *issueAudit) finish() AuditRecord {
+	a.lock.Lock()
+	a.rec.DurationMS = time.Since(a.start).Milliseconds()
+	a.rec.APICalls = int(a.apiCalls.Load())
+	rec := a.rec
+	a.lock.Unlock()
+
+	collectRecord(rec)
+	observeItem(rec)
+	if auditSink != nil {
+		if err := auditSink.Write(rec); err != nil {
+			slog.Warn("Failed to write audit record", "run_id", rec.RunID, "repository", rec.Repository, "issue_number", rec.Number, "error", err)
+		}
+	}
+	return rec
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAuditAPICallsPerIssue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "audit", "repo"
+	cfg.GitHub.APIURL = srv.URL
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "audit-token"
+	gitHubApp = nil
+
+	// Issues processed side by side each count only their own requests.
+	calls := map[int]int{1: 3, 2: 7, 3: 1}
+	got := map[int]int{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for number, n := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, rec := startIssueAudit(context.Background(), Repositories[0], "issue", number)
+			for i := 0; i < n; i++ {
+				if _, err := GetRequest(ctx, srv.URL+"/repos/audit/repo", nil); err != nil {
+					t.Error(err)
+				}
+			}
+			lock.Lock()
+			got[number] = rec.finish().APICalls
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for number, want := range calls {
+		if got[number] != want {
+			t.Errorf("issue #%d api_calls = %d, want %d", number, got[number], want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instead of one GraphQL round-trip per issue, auditRepository prefetches
+// issues in batches: a single query aliases repository.issue once per issue
+// number. Each batch also reads rateLimit { cost remaining }, and the
+// observed cost per issue decides how many issues the next batch may hold
+// without eating into graphQLBudgetReserve.
+
+// graphQLBudgetReserve is the part of the GraphQL budget batches leave
+// untouched for pagination and per-issue fallbacks.
+const graphQLBudgetReserve = 100
+
+// GraphQLRateLimit is the rateLimit object requested with every batch.
+type GraphQLRateLimit struct {
+	Cost      int       `json:"cost"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+var (
+	// Points per issue observed in the last batch, 0 until one ran.
+	batchCostPerIssue float64
+	batchCostLock     sync.Mutex
+)
+
+// nextBatchSize returns how many issues the next batch may hold: at most
+// GraphQLBatchSize, fewer when the remaining GraphQL budget is low, and 0
+// when batching should stop for now.
+func nextBatchSize() int {
+	size := GraphQLBatchSize
+
+	batchCostLock.Lock()
+	perIssue := batchCostPerIssue
+	batchCostLock.Unlock()
+
+	rl, ok := GetRateLimit("graphql")
+	if !ok || perIssue <= 0 {
+		return size
+	}
+	if fit := int(float64(rl.Remaining-graphQLBudgetReserve) / perIssue); fit < size {
+		size = fit
+	}
+	if size < 0 {
+		size = 0
+	}
+	return size
+}
+
+// batchIssueQuery builds the aliased query for a batch of issue numbers.
+func batchIssueQuery(numbers []int) string {
+	var b strings.Builder
+	b.WriteString("query($owner: String!, $name: String!, $commentLimit: Int!, $timelineLimit: Int!, $editLimit: Int!) {\n")
+	b.WriteString("  rateLimit { cost remaining resetAt }\n")
+	b.WriteString("  repository(owner: $owner, name: $name) {\n")
+	for _, n := range numbers {
+		fmt.Fprintf(&b, "    issue%d: issue(number: %d) { ...IssueFields }\n", n, n)
+	}
+	b.WriteString("  }\n}\n")
+	b.WriteString(issueFragment)
+	return b.String()
+}
+
+// prefetchIssues fetches the first issues of numbers in one batched query
+// and stores them for FetchGraphQLData. It returns how many numbers it
+// consumed; issues that failed to load are simply fetched again one by one
+// later. A return of 0 means batching is paused because of the budget.
+func (r *Repository) prefetchIssues(ctx context.Context, numbers []int) int {
+	size := nextBatchSize()
+	if size == 0 {
+		slog.InfoContext(ctx, "GraphQL budget low, fetching the remaining issues one by one")
+		return 0
+	}
+	if size > len(numbers) {
+		size = len(numbers)
+	}
+
+	for {
+		batch := numbers[:size]
+		err := r.fetchIssueBatch(ctx, batch)
+		if err == nil || ctx.Err() != nil {
+			return size
+		}
+
+		// Large batches can run into GitHub's query timeout; retry smaller
+		// before giving up on the batch.
+		var gqlErrs *GraphQLErrors
+		if size == 1 || errors.As(err, &gqlErrs) {
+			slog.WarnContext(ctx, "Batched fetch failed", "numbers", batch, "error", err)
+			return size
+		}
+		size /= 2
+		slog.InfoContext(ctx, "Batched fetch failed, retrying with a smaller batch", "size", len(batch), "retry_size", size, "error", err)
+	}
+}
+
+// fetchIssueBatch runs one batched query and caches every issue it returned.
+func (r *Repository) fetchIssueBatch(ctx context.Context, numbers []int) error {
+	variables := map[string]any{
+		"owner":         r.Owner,
+		"name":          r.Name,
+		"commentLimit":  GraphQLCommentLimit,
+		"editLimit":     GraphQLEditLimit,
+		"timelineLimit": GraphQLTimelineLimit,
+	}
+
+	var data struct {
+		RateLimit  *GraphQLRateLimit        `json:"rateLimit"`
+		Repository map[string]*GraphQLIssue `json:"repository"`
+	}
+
+	err := graphQLQuery(ctx, batchIssueQuery(numbers), variables, &data)
+
+	// A missing issue only fails its own alias; keep the rest of the batch.
+	if err := graphQLDataError(ctx, err, "batch", numbers); err != nil {
+		return err
+	}
+
+	if data.Repository == nil {
+		return notFoundError("repository %s", r.FullName())
+	}
+
+	if data.RateLimit != nil {
+		batchCostLock.Lock()
+		batchCostPerIssue = float64(data.RateLimit.Cost) / float64(len(numbers))
+		batchCostLock.Unlock()
+		slog.InfoContext(ctx, "Prefetched issues",
+			"count", len(numbers), "cost", data.RateLimit.Cost, "graphql_remaining", data.RateLimit.Remaining)
+	}
+
+	r.prefetchLock.Lock()
+	defer r.prefetchLock.Unlock()
+	if r.prefetched == nil {
+		r.prefetched = map[int]*GraphQLIssue{}
+	}
+	for _, n := range numbers {
+		if issue := data.Repository[fmt.Sprintf("issue%d", n)]; issue != nil {
+			r.prefetched[n] = issue
+		}
+	}
+	return nil
+}
+
+// takePrefetched hands out a prefetched issue once, so a second
+// get_issue_state call (e.g. after a tool changed the issue) sees fresh data.
+func (r *Repository) takePrefetched(itemNumber int) (*GraphQLIssue, bool) {
+	r.prefetchLock.Lock()
+	defer r.prefetchLock.Unlock()
+	issue, ok := r.prefetched[itemNumber]
+	if ok {
+		delete(r.prefetched, itemNumber)
+	}
+	return issue, ok
+}
+
+// resetPrefetched drops issues left over from an earlier run, e.g. ones the
+// -issue filter, a timeout or a failed worker never consumed. In daemon mode
+// they would otherwise be served hours later as current data.
+func (r *Repository) resetPrefetched() {
+	r.prefetchLock.Lock()
+	defer r.prefetchLock.Unlock()
+	r.prefetched = nil
+}
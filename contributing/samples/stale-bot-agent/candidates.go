@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// IssueCandidate is the lightweight metadata the GraphQL candidate source
+// returns for every open issue, enough to skip obviously active issues
+// before the agent looks at them.
+type IssueCandidate struct {
+	Number       int
+	UpdatedAt    time.Time
+	LastEditedAt *time.Time
+	Author       string
+	Labels       []string
+}
+
+const issueListQuery = `
+query($owner: String!, $name: String!, $cursor: String, $direction: OrderDirection!, $since: DateTime, $labels: [String!]) {
+  repository(owner: $owner, name: $name) {
+    issues(
+      first: 100
+      after: $cursor
+      states: OPEN
+      orderBy: {field: UPDATED_AT, direction: $direction}
+      filterBy: {since: $since, labels: $labels}
+    ) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number
+        updatedAt
+        lastEditedAt
+        author { login }
+        labels(first: 100) { nodes { name } }
+      }
+    }
+  }
+}
+`
+
+// FindCandidateIssues returns the issue numbers to audit, using the
+// configured CandidateSource.
+func FindCandidateIssues(ctx context.Context, repo *Repository) ([]int, error) {
+	if CandidateSource != "graphql" {
+		return GetOldOpenIssueNumbers(ctx, repo, nil)
+	}
+
+	candidates, err := ListIssueCandidates(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := clock().UTC().Add(-time.Duration(repo.StaleHoursThreshold * float64(time.Hour)))
+	var numbers []int
+	skipped := 0
+	for _, c := range candidates {
+		if isObviouslyActive(repo, c, cutoff) {
+			skipped++
+			continue
+		}
+		numbers = append(numbers, c.Number)
+	}
+
+	slog.InfoContext(ctx, "Found candidate issues", "count", len(numbers), "recently_active_skipped", skipped)
+	return numbers, nil
+}
+
+// isObviouslyActive reports whether an issue can be skipped without asking
+// the agent: it was updated after the stale cutoff, is not marked stale (a
+// reply there must remove the label), and its description was not edited
+// recently (which may need a maintainer alert).
+func isObviouslyActive(repo *Repository, c IssueCandidate, cutoff time.Time) bool {
+	if c.UpdatedAt.Before(cutoff) {
+		return false
+	}
+	if containsString(c.Labels, repo.StaleLabelName) {
+		return false
+	}
+	if c.LastEditedAt != nil && !c.LastEditedAt.Before(cutoff) {
+		return false
+	}
+	return true
+}
+
+// ListIssueCandidates pages repository.issues in two passes split at the
+// stale cutoff: idle issues oldest first, stopping at the cutoff, then the
+// issues updated since the cutoff, which GitHub filters server-side. Unlike
+// the Search API there is no 1000-result ceiling.
+func ListIssueCandidates(ctx context.Context, repo *Repository) ([]IssueCandidate, error) {
+	cutoff := clock().UTC().Add(-time.Duration(repo.StaleHoursThreshold * float64(time.Hour)))
+
+	slog.InfoContext(ctx, "Listing open issues", "updated_before", cutoff.Format(time.RFC3339))
+	idle, err := listIssues(ctx, repo, "ASC", nil, &cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	recent, err := listIssues(ctx, repo, "DESC", &cutoff, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(idle, recent...), nil
+}
+
+// listIssues pages open issues ordered by updatedAt. since filters
+// server-side; before stops paging at the first issue updated at or after it.
+func listIssues(ctx context.Context, repo *Repository, direction string, since, before *time.Time) ([]IssueCandidate, error) {
+	variables := map[string]any{
+		"owner":     repo.Owner,
+		"name":      repo.Name,
+		"direction": direction,
+	}
+	if since != nil {
+		variables["since"] = since.Format(time.RFC3339)
+	}
+	if len(CandidateLabels) > 0 {
+		variables["labels"] = CandidateLabels
+	}
+
+	var candidates []IssueCandidate
+	for {
+		var data struct {
+			Repository *struct {
+				Issues struct {
+					PageInfo GraphQLPageInfo `json:"pageInfo"`
+					Nodes    []*struct {
+						Number       int           `json:"number"`
+						UpdatedAt    time.Time     `json:"updatedAt"`
+						LastEditedAt *time.Time    `json:"lastEditedAt"`
+						Author       *GraphQLActor `json:"author"`
+						Labels       struct {
+							Nodes []*GraphQLLabel `json:"nodes"`
+						} `json:"labels"`
+					} `json:"nodes"`
+				} `json:"issues"`
+			} `json:"repository"`
+		}
+
+		err := graphQLQuery(ctx, issueListQuery, variables, &data)
+		if err := graphQLDataError(ctx, err); err != nil {
+			return candidates, fmt.Errorf("listing issues of %s: %w", repo.FullName(), err)
+		}
+		if data.Repository == nil {
+			return candidates, notFoundError("repository %s", repo.FullName())
+		}
+
+		issues := data.Repository.Issues
+		for _, n := range issues.Nodes {
+			if n == nil {
+				continue
+			}
+			if before != nil && !n.UpdatedAt.Before(*before) {
+				return candidates, nil
+			}
+
+			candidates = append(candidates, IssueCandidate{
+				Number:       n.Number,
+				UpdatedAt:    n.UpdatedAt,
+				LastEditedAt: n.LastEditedAt,
+				Author:       LoginOf(n.Author),
+				Labels:       labelNames(n.Labels.Nodes),
+			})
+		}
+
+		if !issues.PageInfo.HasNextPage {
+			return candidates, nil
+		}
+		variables["cursor"] = issues.PageInfo.EndCursor
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A cassette holds every GitHub request and response of one run. Recording
+// wraps httpClient's transport and appends each exchange to a JSON Lines
+// file; replaying serves the responses from that file instead of the
+// network, so a run's decisions can be reproduced later against exactly
+// what GitHub returned, with a different prompt, model or rule set.
+//
+// The first line is a CassetteHeader. The decision clock is pinned to its
+// RecordedAt in both modes, so time-dependent queries (search cutoffs,
+// "since" filters) and day counts come out identical on replay.
+
+// clock is the time decisions are made at. It is the wall clock except
+// while recording or replaying a cassette.
+var clock = time.Now
+
+// cassetteActive is set while recording or replaying a cassette. Requests
+// must then not depend on the -issue filter, so a filtered replay of an
+// unfiltered recording still finds its responses.
+var cassetteActive bool
+
+type CassetteHeader struct {
+	Cassette   int       `json:"cassette"` // format version
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// CassetteInteraction is one request/response pair. Authorization is never
+// stored and bodies pass through the log redaction.
+type CassetteInteraction struct {
+	Request struct {
+		Method string      `json:"method"`
+		URL    string      `json:"url"` // path and query, without the host
+		Header http.Header `json:"header,omitempty"`
+		Body   string      `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header,omitempty"`
+		Body   string      `json:"body"`
+	} `json:"response"`
+}
+
+func (i *CassetteInteraction) key() string {
+	return cassetteKey(i.Request.Method, i.Request.URL, i.Request.Body)
+}
+
+func cassetteKey(method, url, body string) string {
+	return method + " " + url + "\n" + body
+}
+
+// ---------------- Recording ----------------
+
+// cassetteRecorder is an http.RoundTripper that forwards to next and
+// appends every exchange to the cassette file.
+type cassetteRecorder struct {
+	next http.RoundTripper
+
+	lock sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// startRecording creates the cassette at path, pins the clock and installs
+// the recorder on httpClient.
+func startRecording(path string) (io.Closer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("creating cassette: %w", err)
+	}
+	header := CassetteHeader{Cassette: 1, RecordedAt: time.Now().UTC().Truncate(time.Second)}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing cassette: %w", err)
+	}
+
+	clock = func() time.Time { return header.RecordedAt }
+	cassetteActive = true
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	rec := &cassetteRecorder{next: next, f: f, enc: enc}
+	httpClient.Transport = rec
+	return rec, nil
+}
+
+func (c *cassetteRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		reqBody, _ = io.ReadAll(body)
+		body.Close()
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		// Transport errors are retried by doRequest; only responses are kept.
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var it CassetteInteraction
+	it.Request.Method = req.Method
+	it.Request.URL = req.URL.RequestURI()
+	it.Request.Header = scrubHeader(req.Header)
+	it.Request.Body = redact(string(reqBody))
+	it.Response.Status = resp.StatusCode
+	it.Response.Header = scrubHeader(resp.Header)
+	it.Response.Body = redact(string(respBody))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.enc.Encode(it); err != nil {
+		slog.WarnContext(req.Context(), "Failed to record interaction", "url", it.Request.URL, "error", err)
+	}
+	return resp, nil
+}
+
+func (c *cassetteRecorder) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.f.Close()
+}
+
+// scrubHeader drops credentials and the body length (redaction may change
+// it) and redacts the remaining values.
+func scrubHeader(h http.Header) http.Header {
+	out := http.Header{}
+	for k, vs := range h {
+		switch k {
+		case "Authorization", "Cookie", "Set-Cookie", "Content-Length":
+			continue
+		}
+		for _, v := range vs {
+			out.Add(k, redact(v))
+		}
+	}
+	return out
+}
+
+// ---------------- Replay ----------------
+
+// cassettePlayer is an http.RoundTripper that answers from a cassette.
+// Identical requests get their recorded responses in order, the last one
+// repeating once they run out. A request that was never recorded gets a
+// 501, which doRequest does not retry.
+type cassettePlayer struct {
+	lock      sync.Mutex
+	responses map[string][]*CassetteInteraction
+	served    map[string]int
+}
+
+// startReplay loads the cassette at path, pins the clock to its recording
+// time and installs the player on httpClient.
+func startReplay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening cassette: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1<<20), 64<<20)
+
+	var header CassetteHeader
+	if !scanner.Scan() {
+		return fmt.Errorf("cassette %s is empty", path)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Cassette != 1 {
+		return fmt.Errorf("cassette %s: unsupported header", path)
+	}
+
+	player := &cassettePlayer{
+		responses: map[string][]*CassetteInteraction{},
+		served:    map[string]int{},
+	}
+	count := 0
+	for line := 2; scanner.Scan(); line++ {
+		it := &CassetteInteraction{}
+		if err := json.Unmarshal(scanner.Bytes(), it); err != nil {
+			return fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		player.responses[it.key()] = append(player.responses[it.key()], it)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading cassette: %w", err)
+	}
+
+	clock = func() time.Time { return header.RecordedAt }
+	cassetteActive = true
+	httpClient.Transport = player
+	slog.Info("Replaying cassette", "path", path, "recorded_at", header.RecordedAt, "interactions", count)
+	return nil
+}
+
+func (c *cassettePlayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	url := req.URL.RequestURI()
+	key := cassetteKey(req.Method, url, redact(string(body)))
+
+	c.lock.Lock()
+	recorded := c.responses[key]
+	var it *CassetteInteraction
+	if len(recorded) > 0 {
+		i := min(c.served[key], len(recorded)-1)
+		c.served[key]++
+		it = recorded[i]
+	}
+	c.lock.Unlock()
+
+	if it == nil {
+		slog.WarnContext(req.Context(), "Request not in cassette", "method", req.Method, "url", url)
+		return &http.Response{
+			StatusCode: http.StatusNotImplemented,
+			Status:     "501 Not Implemented",
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"message":"request not recorded in the cassette"}`)),
+			Request:    req,
+		}, nil
+	}
+
+	return &http.Response{
+		StatusCode: it.Response.Status,
+		Status:     fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+		Header:     it.Response.Header.Clone(),
+		Body:       io.NopCloser(strings.NewReader(it.Response.Body)),
+		Request:    req,
+	}, nil
+}
@@ -1,97 +1,695 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	GitHubBaseURL = "https://api.github.com"
-	GitHubToken   string
-
-	Owner        string
-	Repo         string
+	// GitHub endpoints and credentials. GitHubBaseURL is the REST base every
+	// REST URL is built from; GraphQL requests go to GitHubGraphQLURL.
+	GitHubBaseURL    = "https://api.github.com"
+	GitHubGraphQLURL = "https://api.github.com/graphql"
+	GitHubToken      string
+	// Authorization scheme: "token" or "bearer"
+	GitHubAuthScheme = "token"
 
-	// Labels
-	STALE_LABEL_NAME          = "stale"
-	RequestClarificationLabel = "request clarification"
-
-	// Thresholds (hours)
-	STALE_HOURS_THRESHOLD             float64
-	CLOSE_HOURS_AFTER_STALE_THRESHOLD float64
+	// Audit targets with their effective labels and thresholds
+	Repositories []*Repository
 
 	// Performance
 	ConcurrencyLimit int
 
+	// Deadlines: per issue and for the whole run (0 disables)
+	IssueTimeout time.Duration
+	RunTimeout   time.Duration
+
 	// GraphQL limits
 	GraphQLCommentLimit  int
 	GraphQLEditLimit     int
 	GraphQLTimelineLimit int
+	// Cap on the extra pages fetched per issue to complete its history
+	GraphQLMaxHistoryPages int
+	// Issues fetched per batched GraphQL query
+	GraphQLBatchSize int
 
-	// Rate limiting
-	SleepBetweenChunks float64
+	// Candidate issues: "search" (REST search by creation date) or "graphql"
+	// (repository.issues by last update), optionally limited to labels
+	CandidateSource string
+	CandidateLabels []string
+
+	// Dry run: record mutating GitHub calls instead of sending them
+	DryRun bool
+
+	// JSON Lines file every decision is appended to ("" disables)
+	AuditLogPath string
+
+	// Metrics: address serving /metrics and textfile written after every
+	// run ("" disables either)
+	MetricsListen   string
+	MetricsTextfile string
+	// Pause between runs in daemon mode (0 runs once)
+	RunInterval time.Duration
+
+	// Decision engine: "llm" runs the agent, "rules" runs the Go decision tree
+	DecisionEngine string
+	// Comment classifier used by the rules engine: "model" or "heuristic"
+	RulesClassifier string
+	// ModelScript replaces Gemini with a ScriptedModel fixture ("" = Gemini)
+	ModelScriptPath string
+
+	// Comment templates, rendered with formatPrompt
+	StaleCommentTemplate string
+	CloseCommentTemplate string
+	AlertCommentTemplate string
+
+	// Pull requests: audited only when enabled, with their own thresholds
+	// (hours) and comments. A draft threshold of 0 never converts to draft.
+	PullRequestsEnabled      bool
+	PRStaleHoursThreshold    float64
+	PRDraftHoursAfterStale   float64
+	PRCloseHoursAfterStale   float64
+	PRStaleCommentTemplate   string
+	PRStaleCICommentTemplate string
+	PRDraftCommentTemplate   string
+	PRCloseCommentTemplate   string
 )
 
-func InitConfig() {
+// DefaultConfigFile is loaded when present and no other path is given.
+const DefaultConfigFile = "stale-bot.yaml"
 
-	GitHubToken = os.Getenv("GITHUB_TOKEN")
-	log.Printf("GITHUB_TOKEN length: %d", len(GitHubToken))
-	if GitHubToken == "" {
-		log.Fatal("GITHUB_TOKEN environment variable not set")
+// ---------------- Config Schema ----------------
+
+// FileConfig is the schema of stale-bot.yaml. Since YAML is a superset of
+// JSON, the same file may also be written as JSON.
+//
+// Struct tags define the schema:
+//   - yaml:     key name in the file
+//   - env:      environment variable that overrides the key
+//   - validate: comma separated constraints (required, gt=N, min=N, max=N, oneof=a|b)
+type FileConfig struct {
+	Owner string `yaml:"owner" env:"OWNER" validate:"required"`
+	Repo  string `yaml:"repo" env:"REPO" validate:"required"`
+
+	// Repositories, when set, replaces owner/repo with a list of audit
+	// targets. It can also be given as $REPOSITORIES="owner/a,owner/b".
+	Repositories []RepoConfig `yaml:"repositories"`
+
+	// GitHub endpoints. On GitHub Enterprise Server the REST base is
+	// https://HOST/api/v3 and GraphQL is served from https://HOST/api/graphql;
+	// an empty graphql_url is derived from api_url accordingly.
+	GitHub struct {
+		APIURL     string `yaml:"api_url" env:"GITHUB_API_URL" validate:"required"`
+		GraphQLURL string `yaml:"graphql_url" env:"GITHUB_GRAPHQL_URL"`
+		AuthScheme string `yaml:"auth_scheme" env:"GITHUB_AUTH_SCHEME" validate:"oneof=token|bearer"`
+		CABundle   string `yaml:"ca_bundle" env:"GITHUB_CA_BUNDLE"`
+		Proxy      string `yaml:"proxy" env:"GITHUB_PROXY"`
+	} `yaml:"github"`
+
+	// GitHub App credentials. With an app ID the bot authenticates through
+	// installation tokens and GITHUB_TOKEN is not needed. The private key is
+	// read from private_key_file, or from $GITHUB_APP_PRIVATE_KEY.
+	App struct {
+		ID             int    `yaml:"id" env:"GITHUB_APP_ID" validate:"min=0"`
+		PrivateKeyFile string `yaml:"private_key_file" env:"GITHUB_APP_PRIVATE_KEY_FILE"`
+	} `yaml:"app"`
+
+	Labels struct {
+		Stale                string `yaml:"stale" env:"STALE_LABEL_NAME" validate:"required"`
+		RequestClarification string `yaml:"request_clarification" env:"REQUEST_CLARIFICATION_LABEL" validate:"required"`
+	} `yaml:"labels"`
+
+	Thresholds struct {
+		StaleHours           float64 `yaml:"stale_hours" env:"STALE_HOURS_THRESHOLD" validate:"gt=0"`
+		CloseHoursAfterStale float64 `yaml:"close_hours_after_stale" env:"CLOSE_HOURS_AFTER_STALE_THRESHOLD" validate:"gt=0"`
+	} `yaml:"thresholds"`
+
+	GraphQL struct {
+		CommentLimit    int `yaml:"comment_limit" env:"GRAPHQL_COMMENT_LIMIT" validate:"min=1,max=100"`
+		EditLimit       int `yaml:"edit_limit" env:"GRAPHQL_EDIT_LIMIT" validate:"min=1,max=100"`
+		TimelineLimit   int `yaml:"timeline_limit" env:"GRAPHQL_TIMELINE_LIMIT" validate:"min=1,max=100"`
+		MaxHistoryPages int `yaml:"max_history_pages" env:"GRAPHQL_MAX_HISTORY_PAGES" validate:"min=1"`
+		BatchSize       int `yaml:"batch_size" env:"GRAPHQL_BATCH_SIZE" validate:"min=1,max=100"`
+	} `yaml:"graphql"`
+
+	Candidates struct {
+		Source string   `yaml:"source" env:"CANDIDATE_SOURCE" validate:"oneof=search|graphql"`
+		Labels []string `yaml:"labels" env:"CANDIDATE_LABELS"`
+	} `yaml:"candidates"`
+
+	Concurrency        int     `yaml:"concurrency" env:"CONCURRENCY_LIMIT" validate:"min=1"`
+	IssueTimeoutSecs   float64 `yaml:"issue_timeout_seconds" env:"ISSUE_TIMEOUT_SECONDS" validate:"min=0"`
+	RunTimeoutMinutes  float64 `yaml:"run_timeout_minutes" env:"RUN_TIMEOUT_MINUTES" validate:"min=0"`
+	RunIntervalMinutes float64 `yaml:"run_interval_minutes" env:"RUN_INTERVAL_MINUTES" validate:"min=0"`
+
+	// Token buckets shared by all workers: every GitHub request, retries
+	// included, and every model call waits for a token.
+	RateLimit struct {
+		GitHubPerSecond float64 `yaml:"github_per_second" env:"GITHUB_REQUESTS_PER_SECOND" validate:"gt=0"`
+		GitHubBurst     int     `yaml:"github_burst" env:"GITHUB_REQUESTS_BURST" validate:"min=1"`
+		ModelPerMinute  float64 `yaml:"model_per_minute" env:"MODEL_REQUESTS_PER_MINUTE" validate:"gt=0"`
+		ModelBurst      int     `yaml:"model_burst" env:"MODEL_REQUESTS_BURST" validate:"min=1"`
+	} `yaml:"rate_limit"`
+
+	Bot struct {
+		Name           string `yaml:"name" env:"BOT_NAME" validate:"required"`
+		AlertSignature string `yaml:"alert_signature" env:"BOT_ALERT_SIGNATURE" validate:"required"`
+	} `yaml:"bot"`
+
+	Comments struct {
+		Stale string `yaml:"stale" validate:"required"`
+		Close string `yaml:"close" validate:"required"`
+		Alert string `yaml:"alert" validate:"required"`
+	} `yaml:"comments"`
+
+	PullRequests struct {
+		Enabled              bool    `yaml:"enabled" env:"PULL_REQUESTS_ENABLED"`
+		StaleHours           float64 `yaml:"stale_hours" env:"PR_STALE_HOURS_THRESHOLD" validate:"gt=0"`
+		DraftHoursAfterStale float64 `yaml:"draft_hours_after_stale" env:"PR_DRAFT_HOURS_AFTER_STALE" validate:"min=0"`
+		CloseHoursAfterStale float64 `yaml:"close_hours_after_stale" env:"PR_CLOSE_HOURS_AFTER_STALE" validate:"gt=0"`
+
+		Comments struct {
+			Stale   string `yaml:"stale" validate:"required"`
+			StaleCI string `yaml:"stale_ci" validate:"required"`
+			Draft   string `yaml:"draft" validate:"required"`
+			Close   string `yaml:"close" validate:"required"`
+		} `yaml:"comments"`
+	} `yaml:"pull_requests"`
+
+	Model           string `yaml:"model" env:"GEMINI_MODEL" validate:"required"`
+	ModelScript     string `yaml:"model_script" env:"MODEL_SCRIPT"`
+	DecisionEngine  string `yaml:"decision_engine" env:"DECISION_ENGINE" validate:"oneof=llm|rules"`
+	RulesClassifier string `yaml:"rules_classifier" env:"RULES_CLASSIFIER" validate:"oneof=model|heuristic"`
+	DryRun          bool   `yaml:"dry_run" env:"DRY_RUN"`
+	AuditLog        string `yaml:"audit_log" env:"AUDIT_LOG"`
+
+	Logging struct {
+		Format string `yaml:"format" env:"LOG_FORMAT" validate:"oneof=text|json"`
+		Level  string `yaml:"level" env:"LOG_LEVEL" validate:"oneof=debug|info|warn|error"`
+	} `yaml:"logging"`
+
+	// Prometheus metrics. listen serves /metrics for scrapes (daemon mode);
+	// textfile is rewritten after every run for node_exporter's textfile
+	// collector or a Pushgateway push (cron runs).
+	Metrics struct {
+		Listen   string `yaml:"listen" env:"METRICS_LISTEN"`
+		Textfile string `yaml:"textfile" env:"METRICS_TEXTFILE"`
+	} `yaml:"metrics"`
+}
+
+// RepoConfig is one entry of the repositories list. Labels and thresholds
+// left empty inherit the top-level values.
+type RepoConfig struct {
+	Owner string `yaml:"owner"`
+	Repo  string `yaml:"repo"`
+
+	Labels struct {
+		Stale                string `yaml:"stale"`
+		RequestClarification string `yaml:"request_clarification"`
+	} `yaml:"labels"`
+
+	Thresholds struct {
+		StaleHours           float64 `yaml:"stale_hours"`
+		CloseHoursAfterStale float64 `yaml:"close_hours_after_stale"`
+	} `yaml:"thresholds"`
+}
+
+func defaultFileConfig() FileConfig {
+	var c FileConfig
+	c.Owner = "google"
+	c.Repo = "adk-go"
+	c.GitHub.APIURL = "https://api.github.com"
+	c.GitHub.AuthScheme = "token"
+	c.Labels.Stale = "stale"
+	c.Labels.RequestClarification = "request clarification"
+	c.Thresholds.StaleHours = 168.0
+	c.Thresholds.CloseHoursAfterStale = 168.0
+	c.GraphQL.CommentLimit = 30
+	c.GraphQL.EditLimit = 10
+	c.GraphQL.TimelineLimit = 20
+	c.GraphQL.MaxHistoryPages = 10
+	c.GraphQL.BatchSize = 25
+	c.Candidates.Source = "search"
+	c.Concurrency = 3
+	c.IssueTimeoutSecs = 300
+	c.RateLimit.GitHubPerSecond = 5
+	c.RateLimit.GitHubBurst = 10
+	c.RateLimit.ModelPerMinute = 60
+	c.RateLimit.ModelBurst = 5
+	c.Bot.Name = "adk-bot"
+	c.Bot.AlertSignature = "**Notification:** The author has updated the issue description"
+	c.Comments.Stale = "This issue has been automatically marked as stale because it has not" +
+		" had recent activity for {stale_days} days after a maintainer" +
+		" requested clarification. It will be closed if no further activity" +
+		" occurs within {close_days} days."
+	c.Comments.Close = "This has been automatically closed because it has been marked as stale" +
+		" for over {close_days} days."
+	c.Comments.Alert = "{alert_signature}. Maintainers, please review."
+	c.PullRequests.StaleHours = 336.0
+	c.PullRequests.DraftHoursAfterStale = 168.0
+	c.PullRequests.CloseHoursAfterStale = 336.0
+	c.PullRequests.Comments.Stale = "This pull request has been automatically marked as stale because" +
+		" changes were requested {stale_days} days ago and no new commits have been pushed since." +
+		" It will be closed if there is no further activity within {close_days} days."
+	c.PullRequests.Comments.StaleCI = "This pull request has been automatically marked as stale because" +
+		" its checks have been failing for {stale_days} days and no new commits have been pushed since." +
+		" It will be closed if there is no further activity within {close_days} days."
+	c.PullRequests.Comments.Draft = "Converting this pull request to a draft while it waits for new" +
+		" commits. Mark it ready for review once they are pushed."
+	c.PullRequests.Comments.Close = "This pull request has been automatically closed because it has been" +
+		" marked as stale for over {close_days} days. Feel free to reopen it once it is updated."
+	c.Model = "gemini-2.5-pro"
+	c.DecisionEngine = "llm"
+	c.RulesClassifier = "model"
+	c.AuditLog = "stale-bot-audit.jsonl"
+	c.Logging.Format = "text"
+	c.Logging.Level = "info"
+	return c
+}
+
+// ConfigError lists every problem found while loading the configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// ---------------- Loading ----------------
+
+// LoadFileConfig reads the config file at path (if any) on top of the
+// defaults, applies environment overrides and validates the result.
+// A missing file is only an error when required is set.
+func LoadFileConfig(path string, required bool) (FileConfig, error) {
+	cfg := defaultFileConfig()
+
+	if path != "" {
+		f, err := os.Open(path)
+		switch {
+		case err == nil:
+			defer f.Close()
+			dec := yaml.NewDecoder(f)
+			dec.KnownFields(true)
+			if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+				return cfg, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			slog.Info("Loaded config file", "path", path)
+		case errors.Is(err, os.ErrNotExist) && !required:
+			slog.Info("No config file, using defaults and environment.", "path", path)
+		default:
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
 	}
 
-	// Repo
-	Owner = getEnv("OWNER", "google")
-	Repo = getEnv("REPO", "adk-go")
+	if raw := os.Getenv("REPOSITORIES"); raw != "" {
+		cfg.Repositories = nil
+		for _, full := range strings.Split(raw, ",") {
+			owner, name, _ := strings.Cut(strings.TrimSpace(full), "/")
+			cfg.Repositories = append(cfg.Repositories, RepoConfig{Owner: owner, Repo: name})
+		}
+	}
 
-	// Thresholds (hours)
-	STALE_HOURS_THRESHOLD = getEnvFloat("STALE_HOURS_THRESHOLD", 168.0)
-	CLOSE_HOURS_AFTER_STALE_THRESHOLD =getEnvFloat("CLOSE_HOURS_AFTER_STALE_THRESHOLD",168.0)
+	var problems []string
+	problems = append(problems, applyEnvOverrides(reflect.ValueOf(&cfg).Elem(), "")...)
+	problems = append(problems, validateConfig(reflect.ValueOf(cfg), "")...)
+	problems = append(problems, validateRepositories(cfg.Repositories)...)
+	if len(problems) > 0 {
+		return cfg, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
 
-	// Performance
-	ConcurrencyLimit = getEnvInt("CONCURRENCY_LIMIT", 3)
+// applyEnvOverrides sets every field that has an env tag whose variable is
+// set to a non-empty value. Unparseable values are reported, never ignored.
+func applyEnvOverrides(v reflect.Value, prefix string) []string {
+	var problems []string
+	t := v.Type()
 
-	GraphQLCommentLimit = getEnvInt("GRAPHQL_COMMENT_LIMIT", 30)
-	GraphQLEditLimit = getEnvInt("GRAPHQL_EDIT_LIMIT", 10)
-	GraphQLTimelineLimit = getEnvInt("GRAPHQL_TIMELINE_LIMIT", 20)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		key := joinKey(prefix, yamlKey(field))
 
-	// Rate limiting
-	SleepBetweenChunks = getEnvFloat("SLEEP_BETWEEN_CHUNKS", 1.5)
+		if field.Type.Kind() == reflect.Struct {
+			problems = append(problems, applyEnvOverrides(fv, key)...)
+			continue
+		}
 
-	// Sanity log
-	log.Printf(
-		"Config loaded → repo=%s/%s stale=%.2fh close=%.2fh", Owner, Repo, STALE_HOURS_THRESHOLD, CLOSE_HOURS_AFTER_STALE_THRESHOLD,
-	)
+		envName := field.Tag.Get("env")
+		if envName == "" {
+			continue
+		}
+		raw := os.Getenv(envName)
+		if raw == "" {
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from $%s): %q is not an integer", key, envName, raw))
+				continue
+			}
+			fv.SetInt(int64(n))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from $%s): %q is not a number", key, envName, raw))
+				continue
+			}
+			fv.SetFloat(f)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from $%s): %q is not a boolean", key, envName, raw))
+				continue
+			}
+			fv.SetBool(b)
+		case reflect.Slice:
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(items))
+		}
+	}
+	return problems
 }
 
-func getEnv(key, fallback string) string {
-	if v, ok := os.LookupEnv(key); ok {
-		return v
+// validateConfig checks every field against its validate tag.
+func validateConfig(v reflect.Value, prefix string) []string {
+	var problems []string
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		key := joinKey(prefix, yamlKey(field))
+
+		if field.Type.Kind() == reflect.Struct {
+			problems = append(problems, validateConfig(fv, key)...)
+			continue
+		}
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(rule, "=")
+			if msg := checkRule(fv, name, arg); msg != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", key, msg))
+			}
+		}
 	}
-	return fallback
+	return problems
 }
 
-func getEnvInt(key string, fallback int) int {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
+// validateRepositories checks the repositories list, which the tag-based
+// validator does not descend into.
+func validateRepositories(repos []RepoConfig) []string {
+	var problems []string
+	seen := map[string]bool{}
+
+	for i, rc := range repos {
+		key := fmt.Sprintf("repositories[%d]", i)
+		if rc.Owner == "" {
+			problems = append(problems, key+".owner: is required")
+		}
+		if rc.Repo == "" {
+			problems = append(problems, key+".repo: is required")
+		}
+		full := rc.Owner + "/" + rc.Repo
+		if seen[full] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate repository %s", key, full))
+		}
+		seen[full] = true
+
+		if rc.Thresholds.StaleHours < 0 {
+			problems = append(problems, fmt.Sprintf("%s.thresholds.stale_hours: must be greater than 0 (got %v)", key, rc.Thresholds.StaleHours))
+		}
+		if rc.Thresholds.CloseHoursAfterStale < 0 {
+			problems = append(problems, fmt.Sprintf("%s.thresholds.close_hours_after_stale: must be greater than 0 (got %v)", key, rc.Thresholds.CloseHoursAfterStale))
+		}
 	}
-	i, err := strconv.Atoi(v)
-	if err != nil {
-		return fallback
+	return problems
+}
+
+// buildRepositories resolves the audit targets, applying the top-level
+// labels and thresholds wherever a repository does not override them.
+func buildRepositories(cfg FileConfig) []*Repository {
+	targets := cfg.Repositories
+	if len(targets) == 0 {
+		targets = []RepoConfig{{Owner: cfg.Owner, Repo: cfg.Repo}}
+	}
+
+	repos := make([]*Repository, 0, len(targets))
+	for _, rc := range targets {
+		repo := &Repository{
+			Owner:                         rc.Owner,
+			Name:                          rc.Repo,
+			StaleLabelName:                cfg.Labels.Stale,
+			RequestClarificationLabel:     cfg.Labels.RequestClarification,
+			StaleHoursThreshold:           cfg.Thresholds.StaleHours,
+			CloseHoursAfterStaleThreshold: cfg.Thresholds.CloseHoursAfterStale,
+		}
+		if rc.Labels.Stale != "" {
+			repo.StaleLabelName = rc.Labels.Stale
+		}
+		if rc.Labels.RequestClarification != "" {
+			repo.RequestClarificationLabel = rc.Labels.RequestClarification
+		}
+		if rc.Thresholds.StaleHours > 0 {
+			repo.StaleHoursThreshold = rc.Thresholds.StaleHours
+		}
+		if rc.Thresholds.CloseHoursAfterStale > 0 {
+			repo.CloseHoursAfterStaleThreshold = rc.Thresholds.CloseHoursAfterStale
+		}
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+func checkRule(fv reflect.Value, name, arg string) string {
+	var num float64
+	switch fv.Kind() {
+	case reflect.Int:
+		num = float64(fv.Int())
+	case reflect.Float64:
+		num = fv.Float()
+	}
+	limit, _ := strconv.ParseFloat(arg, 64)
+
+	switch name {
+	case "required":
+		if strings.TrimSpace(fv.String()) == "" {
+			return "is required"
+		}
+	case "gt":
+		if num <= limit {
+			return fmt.Sprintf("must be greater than %s (got %v)", arg, fv.Interface())
+		}
+	case "min":
+		if num < limit {
+			return fmt.Sprintf("must be at least %s (got %v)", arg, fv.Interface())
+		}
+	case "max":
+		if num > limit {
+			return fmt.Sprintf("must be at most %s (got %v)", arg, fv.Interface())
+		}
+	case "oneof":
+		allowed := strings.Split(arg, "|")
+		for _, a := range allowed {
+			if fv.String() == a {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s (got %q)", strings.Join(allowed, ", "), fv.String())
+	}
+	return ""
+}
+
+func yamlKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
 	}
-	return i
+	return prefix + "." + key
 }
 
-func getEnvFloat(key string, fallback float64) float64 {
-	v := os.Getenv(key)
-	if v == "" {
-		return fallback
+// ---------------- Init ----------------
+
+// InitConfig loads the configuration into the package globals and exits on
+// any invalid value. An empty path falls back to $STALE_BOT_CONFIG and then
+// to an optional DefaultConfigFile. Offline runs (cassette replays and
+// evals) never reach GitHub and do not require GITHUB_TOKEN.
+func InitConfig(path string, offline bool) {
+
+	// Credentials never appear in the logs, not even as a length.
+	GitHubToken = os.Getenv("GITHUB_TOKEN")
+	registerSecret(GitHubToken)
+	registerSecret(os.Getenv("GOOGLE_API_KEY"))
+
+	required := true
+	if path == "" {
+		path = os.Getenv("STALE_BOT_CONFIG")
+	}
+	if path == "" {
+		path = DefaultConfigFile
+		required = false
 	}
-	f, err := strconv.ParseFloat(v, 64)
+
+	cfg, err := LoadFileConfig(path, required)
 	if err != nil {
-		return fallback
+		fatal("Failed to load configuration", "error", err)
+	}
+
+	if err := configureLogging(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		fatal("Failed to configure logging", "error", err)
+	}
+
+	// Credentials: GitHub App when configured, GITHUB_TOKEN otherwise
+	if cfg.App.ID != 0 {
+		gitHubApp, err = loadGitHubApp(cfg)
+		if err != nil {
+			fatal("Failed to load GitHub App credentials", "error", err)
+		}
+	} else if GitHubToken == "" && !offline {
+		fatal("GITHUB_TOKEN environment variable not set")
+	}
+
+	if err := applyConfig(cfg); err != nil {
+		fatal("Failed to apply configuration", "error", err)
+	}
+
+	// Sanity log
+	for _, r := range Repositories {
+		slog.Info("Config loaded",
+			"repository", r.FullName(),
+			"stale_hours", r.StaleHoursThreshold,
+			"close_hours", r.CloseHoursAfterStaleThreshold,
+			"stale_label", r.StaleLabelName,
+		)
+	}
+	slog.Info("Config loaded", "repositories", len(Repositories), "dry_run", DryRun, "engine", DecisionEngine)
+	slog.Info("Config loaded", "rest", GitHubBaseURL, "graphql", GitHubGraphQLURL)
+}
+
+// applyConfig sets the package globals from a loaded configuration.
+// Credentials are left to the caller.
+func applyConfig(cfg FileConfig) error {
+	// GitHub endpoints and transport
+	GitHubBaseURL = strings.TrimSuffix(cfg.GitHub.APIURL, "/")
+	GitHubGraphQLURL = cfg.GitHub.GraphQLURL
+	if GitHubGraphQLURL == "" {
+		GitHubGraphQLURL = deriveGraphQLURL(GitHubBaseURL)
+	}
+	GitHubAuthScheme = cfg.GitHub.AuthScheme
+	if err := configureHTTPClient(cfg.GitHub.CABundle, cfg.GitHub.Proxy); err != nil {
+		return fmt.Errorf("configuring the GitHub HTTP client: %w", err)
+	}
+
+	// Repositories, labels and thresholds
+	Repositories = buildRepositories(cfg)
+
+	// Performance
+	ConcurrencyLimit = cfg.Concurrency
+	IssueTimeout = time.Duration(cfg.IssueTimeoutSecs * float64(time.Second))
+	RunTimeout = time.Duration(cfg.RunTimeoutMinutes * float64(time.Minute))
+	RunInterval = time.Duration(cfg.RunIntervalMinutes * float64(time.Minute))
+
+	GraphQLCommentLimit = cfg.GraphQL.CommentLimit
+	GraphQLEditLimit = cfg.GraphQL.EditLimit
+	GraphQLTimelineLimit = cfg.GraphQL.TimelineLimit
+	GraphQLMaxHistoryPages = cfg.GraphQL.MaxHistoryPages
+	GraphQLBatchSize = cfg.GraphQL.BatchSize
+
+	CandidateSource = cfg.Candidates.Source
+	CandidateLabels = cfg.Candidates.Labels
+
+	// Rate limiting
+	githubLimiter = NewTokenBucket(cfg.RateLimit.GitHubPerSecond, cfg.RateLimit.GitHubBurst)
+	modelLimiter = NewTokenBucket(cfg.RateLimit.ModelPerMinute/60, cfg.RateLimit.ModelBurst)
+
+	// Bot identity and comments
+	BOT_NAME = cfg.Bot.Name
+	BOT_ALERT_SIGNATURE = cfg.Bot.AlertSignature
+	StaleCommentTemplate = cfg.Comments.Stale
+	CloseCommentTemplate = cfg.Comments.Close
+	AlertCommentTemplate = cfg.Comments.Alert
+
+	// Pull requests
+	PullRequestsEnabled = cfg.PullRequests.Enabled
+	PRStaleHoursThreshold = cfg.PullRequests.StaleHours
+	PRDraftHoursAfterStale = cfg.PullRequests.DraftHoursAfterStale
+	PRCloseHoursAfterStale = cfg.PullRequests.CloseHoursAfterStale
+	PRStaleCommentTemplate = cfg.PullRequests.Comments.Stale
+	PRStaleCICommentTemplate = cfg.PullRequests.Comments.StaleCI
+	PRDraftCommentTemplate = cfg.PullRequests.Comments.Draft
+	PRCloseCommentTemplate = cfg.PullRequests.Comments.Close
+
+	// Model and decision engine
+	geminiModel = cfg.Model
+	ModelScriptPath = cfg.ModelScript
+	DecisionEngine = cfg.DecisionEngine
+	RulesClassifier = cfg.RulesClassifier
+
+	// Dry run
+	DryRun = cfg.DryRun
+
+	// Audit log
+	AuditLogPath = cfg.AuditLog
+
+	// Metrics
+	MetricsListen = cfg.Metrics.Listen
+	MetricsTextfile = cfg.Metrics.Textfile
+	return nil
+}
+
+// loadGitHubApp reads the app's private key from the configured file or
+// from $GITHUB_APP_PRIVATE_KEY.
+func loadGitHubApp(cfg FileConfig) (*GitHubApp, error) {
+	key := []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	if cfg.App.PrivateKeyFile != "" {
+		var err error
+		key, err = os.ReadFile(cfg.App.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading private key: %w", err)
+		}
+	}
+	if len(key) == 0 {
+		return nil, errors.New("app.id is set but neither app.private_key_file nor $GITHUB_APP_PRIVATE_KEY is")
+	}
+	return NewGitHubApp(cfg.App.ID, key)
+}
+
+// deriveGraphQLURL maps a REST base to its GraphQL endpoint: GitHub Enterprise
+// Server serves REST under /api/v3 and GraphQL under /api/graphql, github.com
+// serves both from the API host.
+func deriveGraphQLURL(restBase string) string {
+	if host, ok := strings.CutSuffix(restBase, "/api/v3"); ok {
+		return host + "/api/graphql"
 	}
-	return f
+	return restBase + "/graphql"
 }
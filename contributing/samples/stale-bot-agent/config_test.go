@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeriveGraphQLURL(t *testing.T) {
+	tests := []struct {
+		rest string
+		want string
+	}{
+		{"https://api.github.com", "https://api.github.com/graphql"},
+		{"https://github.example.com/api/v3", "https://github.example.com/api/graphql"},
+		{"http://127.0.0.1:8080/api/v3", "http://127.0.0.1:8080/api/graphql"},
+		{"https://ghe.example.com/api/v3x", "https://ghe.example.com/api/v3x/graphql"},
+	}
+	for _, tt := range tests {
+		if got := deriveGraphQLURL(tt.rest); got != tt.want {
+			t.Errorf("deriveGraphQLURL(%q) = %q, want %q", tt.rest, got, tt.want)
+		}
+	}
+}
+
+// endpointRecorder stands in for GitHub. It answers the collaborators,
+// search and GraphQL calls with empty results and records each request's
+// host, path and Authorization header.
+type endpointRecorder struct {
+	lock     sync.Mutex
+	requests []string
+	auth     []string
+}
+
+func (e *endpointRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	e.requests = append(e.requests, r.Method+" "+r.Host+r.URL.Path)
+	e.auth = append(e.auth, r.Header.Get("Authorization"))
+	e.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost:
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"viewer": map[string]any{"login": "bot"}}})
+	case filepath.Base(r.URL.Path) == "issues":
+		json.NewEncoder(w).Encode(map[string]any{"items": []any{}})
+	default:
+		json.NewEncoder(w).Encode([]any{map[string]any{"login": "maintainer-a"}})
+	}
+}
+
+// callEveryEndpoint makes one REST, one search and one GraphQL call.
+func callEveryEndpoint(ctx context.Context) error {
+	repo := Repositories[0]
+	if _, err := repo.getCachedMaintainers(ctx); err != nil {
+		return err
+	}
+	if _, err := GetOldOpenPullRequestNumbers(ctx, repo); err != nil {
+		return err
+	}
+	var data struct {
+		Viewer struct{ Login string } `json:"viewer"`
+	}
+	return graphQLQuery(ctx, `query { viewer { login } }`, nil, &data)
+}
+
+// writeCA writes the certificate of a TLS test server as a PEM bundle.
+func writeCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGitHubEnterpriseServer(t *testing.T) {
+	rec := &endpointRecorder{}
+	srv := httptest.NewTLSServer(rec)
+	defer srv.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "ghes", "repo"
+	cfg.GitHub.APIURL = srv.URL + "/api/v3"
+	cfg.GitHub.AuthScheme = "bearer"
+	cfg.GitHub.CABundle = writeCA(t, srv)
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "ghes-token"
+
+	if err := callEveryEndpoint(context.Background()); err != nil {
+		t.Fatalf("calling GHES through the CA bundle: %v", err)
+	}
+
+	host := srv.Listener.Addr().String()
+	want := []string{
+		"GET " + host + "/api/v3/repos/ghes/repo/collaborators",
+		"GET " + host + "/api/v3/search/issues",
+		"POST " + host + "/api/graphql",
+	}
+	if len(rec.requests) != len(want) {
+		t.Fatalf("requests = %q, want %q", rec.requests, want)
+	}
+	for i := range want {
+		if rec.requests[i] != want[i] {
+			t.Errorf("request %d = %q, want %q", i, rec.requests[i], want[i])
+		}
+		if rec.auth[i] != "Bearer ghes-token" {
+			t.Errorf("request %d Authorization = %q, want the bearer scheme", i, rec.auth[i])
+		}
+	}
+}
+
+func TestGitHubEnterpriseServerUntrusted(t *testing.T) {
+	rec := &endpointRecorder{}
+	srv := httptest.NewTLSServer(rec)
+	defer srv.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "ghes", "repo"
+	cfg.GitHub.APIURL = srv.URL + "/api/v3"
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "ghes-token"
+
+	// The first attempt fails the handshake; the deadline ends the retries.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Repositories[0].getCachedMaintainers(ctx); err == nil {
+		t.Fatal("a server outside the CA bundle was trusted")
+	}
+	if len(rec.requests) != 0 {
+		t.Errorf("server received %q", rec.requests)
+	}
+}
+
+func TestGitHubThroughProxy(t *testing.T) {
+	// An HTTP proxy receives absolute request URIs; this one serves them
+	// itself, as if it had forwarded them to the github.com layout.
+	rec := &endpointRecorder{}
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "octo", "repo"
+	cfg.GitHub.APIURL = "http://api.github.test"
+	cfg.GitHub.Proxy = proxy.URL
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "proxy-token"
+
+	if err := callEveryEndpoint(context.Background()); err != nil {
+		t.Fatalf("calling GitHub through the proxy: %v", err)
+	}
+
+	want := []string{
+		"GET api.github.test/repos/octo/repo/collaborators",
+		"GET api.github.test/search/issues",
+		"POST api.github.test/graphql",
+	}
+	if len(rec.requests) != len(want) {
+		t.Fatalf("proxied requests = %q, want %q", rec.requests, want)
+	}
+	for i := range want {
+		if rec.requests[i] != want[i] {
+			t.Errorf("proxied request %d = %q, want %q", i, rec.requests[i], want[i])
+		}
+		if rec.auth[i] != "token proxy-token" {
+			t.Errorf("request %d Authorization = %q, want the token scheme", i, rec.auth[i])
+		}
+	}
+}
+
+func TestConfigureHTTPClientErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		caBundle string
+		proxy    string
+	}{
+		{"missing bundle", filepath.Join(t.TempDir(), "missing.pem"), ""},
+		{"bundle without certificates", empty, ""},
+		{"proxy without host", "", "not-a-url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := configureHTTPClient(tt.caBundle, tt.proxy); err == nil {
+				t.Error("configureHTTPClient succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned for every GitHub response outside the 2xx range.
+type APIError struct {
+	StatusCode       int
+	Message          string
+	DocumentationURL string
+	RequestID        string
+	Method           string
+	URL              string
+	// Details holds the per-field "errors" array GitHub sends with 422s.
+	Details []APIErrorDetail
+}
+
+// APIErrorDetail is one entry of GitHub's validation "errors" array.
+type APIErrorDetail struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+	for _, d := range e.Details {
+		if d.Message != "" {
+			msg += "; " + d.Message
+		} else {
+			msg += fmt.Sprintf("; %s.%s %s", d.Resource, d.Field, d.Code)
+		}
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+// newAPIError builds an APIError from a non-2xx response and closes its body.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	defer resp.Body.Close()
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-GitHub-Request-Id"),
+		Method:     req.Method,
+		URL:        req.URL.String(),
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	var payload struct {
+		Message          string           `json:"message"`
+		DocumentationURL string           `json:"documentation_url"`
+		Errors           []APIErrorDetail `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if payload.Message != "" {
+			apiErr.Message = payload.Message
+		}
+		apiErr.DocumentationURL = payload.DocumentationURL
+		apiErr.Details = payload.Errors
+	} else if text := strings.TrimSpace(string(body)); text != "" {
+		apiErr.Message = text
+	}
+	return apiErr
+}
+
+func apiStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a GitHub 404 or a node missing from a
+// GraphQL response.
+func IsNotFound(err error) bool {
+	return apiStatus(err) == http.StatusNotFound || errors.Is(err, ErrNotFound)
+}
+
+// IsValidation reports whether err is a GitHub 422 validation failure.
+func IsValidation(err error) bool {
+	return apiStatus(err) == http.StatusUnprocessableEntity
+}
+
+// IsAuth reports whether err is an authentication or permission failure.
+func IsAuth(err error) bool {
+	status := apiStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// toolFailure maps a failed GitHub call made by a tool into a ToolResult the
+// model can reason about. API errors are reported through the result only,
+// so the agent sees the explanation rather than a bare tool error.
+func toolFailure(action string, err error) (ToolResult, error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return ToolResult{
+			Status:  "failure",
+			Message: fmt.Sprintf("error %s: %v", action, err),
+		}, err
+	}
+
+	var reason string
+	switch {
+	case IsNotFound(err):
+		reason = "GitHub returned 404 Not Found: the issue, label or repository does not exist."
+	case IsValidation(err):
+		reason = fmt.Sprintf("GitHub rejected the request as invalid (422): %s.", apiErr.Message)
+	case IsAuth(err):
+		reason = fmt.Sprintf("The bot is not authorized to do this (%d): %s. Do not retry.", apiErr.StatusCode, apiErr.Message)
+	default:
+		reason = fmt.Sprintf("GitHub returned %d: %s.", apiErr.StatusCode, apiErr.Message)
+	}
+
+	return ToolResult{
+		Status:  "failure",
+		Message: fmt.Sprintf("error %s: %s", action, reason),
+	}, nil
+}
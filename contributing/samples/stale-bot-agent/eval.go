@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/model/gemini"
+	"google.golang.org/adk/tool"
+	"google.golang.org/genai"
+	"gopkg.in/yaml.v3"
+)
+
+// The eval runs the agent over a labeled dataset of get_issue_state
+// snapshots, once per model, and scores its verdicts and tool calls. The
+// agent gets the real instruction and tool declarations, but the tools
+// answer from the dataset: get_issue_state returns the snapshot and the
+// mutating tools only record the call, so nothing reaches GitHub.
+
+// Eval verdicts: the report's verdict, with STALE split into CLOSE when
+// the issue was closed. EvalNone is a run without a recognizable verdict.
+const (
+	EvalClose = "CLOSE"
+	EvalNone  = "NONE"
+)
+
+var evalVerdicts = []string{VerdictActive, VerdictPending, VerdictStale, EvalClose}
+
+// EvalDataset is the dataset file.
+type EvalDataset struct {
+	// Pricing in USD per million tokens, by model name; models without an
+	// entry report no cost.
+	Pricing map[string]struct {
+		Input  float64 `yaml:"input"`
+		Output float64 `yaml:"output"`
+	} `yaml:"pricing"`
+
+	// Thresholds fail the eval when crossed by any model. The drop is
+	// measured against the -eval-baseline report and only checked when
+	// one is given.
+	Thresholds struct {
+		MinVerdictAccuracy float64 `yaml:"min_verdict_accuracy"`
+		MinToolAccuracy    float64 `yaml:"min_tool_accuracy"`
+		MaxAccuracyDrop    float64 `yaml:"max_accuracy_drop"`
+	} `yaml:"thresholds"`
+
+	Examples []EvalExample `yaml:"examples"`
+}
+
+type EvalExample struct {
+	Name            string         `yaml:"name"`
+	Number          int            `yaml:"number"`
+	ExpectedVerdict string         `yaml:"expected_verdict"`
+	ExpectedTools   []EvalToolCall `yaml:"expected_tools"`
+	State           map[string]any `yaml:"state"`
+}
+
+// EvalToolCall is a mutating tool call; get_issue_state is not scored.
+type EvalToolCall struct {
+	Tool  string `yaml:"tool" json:"tool"`
+	Label string `yaml:"label,omitempty" json:"label,omitempty"`
+}
+
+func (c EvalToolCall) String() string {
+	if c.Label != "" {
+		return c.Tool + "(" + c.Label + ")"
+	}
+	return c.Tool
+}
+
+// EvalResult is one model's scores.
+type EvalResult struct {
+	Model           string  `json:"model"`
+	Examples        int     `json:"examples"`
+	Errors          int     `json:"errors"`
+	VerdictCorrect  int     `json:"verdict_correct"`
+	VerdictAccuracy float64 `json:"verdict_accuracy"`
+	ToolCorrect     int     `json:"tool_correct"`
+	ToolAccuracy    float64 `json:"tool_accuracy"`
+
+	// Confusion[expected][predicted]
+	Confusion map[string]map[string]int `json:"confusion"`
+
+	ModelCalls   int      `json:"model_calls"`
+	InputTokens  int      `json:"input_tokens"`
+	OutputTokens int      `json:"output_tokens"`
+	CostUSD      *float64 `json:"cost_usd,omitempty"`
+
+	LatencyMeanMS int64 `json:"latency_mean_ms"`
+	LatencyP50MS  int64 `json:"latency_p50_ms"`
+	LatencyP95MS  int64 `json:"latency_p95_ms"`
+
+	Misses []EvalMiss `json:"misses,omitempty"`
+}
+
+// EvalMiss is an example the model got wrong.
+type EvalMiss struct {
+	Name          string   `json:"name"`
+	Number        int      `json:"number"`
+	Expected      string   `json:"expected"`
+	Predicted     string   `json:"predicted"`
+	ExpectedTools []string `json:"expected_tools"`
+	Tools         []string `json:"tools"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func loadEvalDataset(path string) (*EvalDataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ds EvalDataset
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&ds); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var problems []string
+	seen := map[int]bool{}
+	for i, ex := range ds.Examples {
+		if ex.Number <= 0 || seen[ex.Number] {
+			problems = append(problems, fmt.Sprintf("examples[%d] (%s): number must be unique and positive", i, ex.Name))
+		}
+		seen[ex.Number] = true
+		if !containsString(evalVerdicts, ex.ExpectedVerdict) {
+			problems = append(problems, fmt.Sprintf("examples[%d] (%s): expected_verdict must be one of %s", i, ex.Name, strings.Join(evalVerdicts, "|")))
+		}
+		if ex.State == nil {
+			problems = append(problems, fmt.Sprintf("examples[%d] (%s): state is required", i, ex.Name))
+		}
+	}
+	if len(ds.Examples) == 0 {
+		problems = append(problems, "no examples")
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	return &ds, nil
+}
+
+// ---------------- Tools ----------------
+
+// evalTools declares the same tools as setupTools with the dataset behind
+// them.
+func evalTools(repo *Repository, ds *EvalDataset) []tool.Tool {
+	states := map[int]map[string]any{}
+	for _, ex := range ds.Examples {
+		state := map[string]any{"status": "success"}
+		for k, v := range ex.State {
+			state[k] = v
+		}
+		states[ex.Number] = state
+	}
+
+	getState := func(ctx context.Context, args IssueTargetArgs) (map[string]any, error) {
+		state, ok := states[args.IssueNumber]
+		if !ok {
+			return errorResponse(fmt.Sprintf("issue #%d is not in the eval dataset", args.IssueNumber)), nil
+		}
+		auditFrom(ctx).setState(state)
+		return state, nil
+	}
+	recorded := func(ctx context.Context, args IssueTargetArgs) (ToolResult, error) {
+		return ToolResult{Status: "success"}, nil
+	}
+	recordedLabel := func(ctx context.Context, args LabelTargetArgs) (ToolResult, error) {
+		return ToolResult{Status: "success"}, nil
+	}
+
+	return buildIssueTools(repo, issueToolset{
+		AddLabel:      recordedLabel,
+		RemoveLabel:   recordedLabel,
+		MarkStale:     recorded,
+		AlertEdit:     recorded,
+		CloseAsStale:  recorded,
+		GetIssueState: getState,
+	})
+}
+
+// ---------------- Running ----------------
+
+// runEval evaluates every model on the dataset, prints the scores as
+// Markdown, optionally writes them as JSON, and returns the process exit
+// code: 1 when a threshold is crossed.
+func runEval(ctx context.Context, datasetPath string, models []string, jsonPath, baselinePath string) int {
+	ds, err := loadEvalDataset(datasetPath)
+	if err != nil {
+		slog.Error("Failed to load eval dataset", "error", err)
+		return 1
+	}
+	repo := Repositories[0]
+	RunID = newRunID()
+	ctx = withLogAttrs(ctx, "run_id", RunID)
+
+	var results []EvalResult
+	for _, name := range models {
+		name = strings.TrimSpace(name)
+		var base model.LLM
+		if ModelScriptPath != "" {
+			base, err = LoadScriptedModel(ModelScriptPath)
+		} else {
+			base, err = gemini.NewModel(ctx, name, &genai.ClientConfig{APIKey: os.Getenv("GOOGLE_API_KEY")})
+		}
+		if err != nil {
+			slog.Error("Failed to create model", "model", name, "error", err)
+			return 1
+		}
+
+		slog.Info("Evaluating model", "model", base.Name(), "examples", len(ds.Examples))
+		res, err := evalModel(ctx, repo, ds, meteredModel{limitedModel{base}})
+		if err != nil {
+			slog.Error("Eval failed", "model", base.Name(), "error", err)
+			return 1
+		}
+		results = append(results, res)
+	}
+
+	fmt.Print(renderEvalMarkdown(results))
+
+	if jsonPath != "" {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		if err := os.WriteFile(jsonPath, append(data, '\n'), 0o644); err != nil {
+			slog.Error("Failed to write eval report", "error", err)
+			return 1
+		}
+	}
+
+	var baseline []EvalResult
+	if baselinePath != "" {
+		data, err := os.ReadFile(baselinePath)
+		if err == nil {
+			err = json.Unmarshal(data, &baseline)
+		}
+		if err != nil {
+			slog.Error("Failed to read eval baseline", "error", err)
+			return 1
+		}
+	}
+
+	failures := checkEvalThresholds(ds, results, baseline)
+	for _, f := range failures {
+		slog.Error("Eval threshold crossed", "problem", f)
+	}
+	if len(failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// evalModel runs the agent over every example with llm and scores it.
+func evalModel(ctx context.Context, repo *Repository, ds *EvalDataset, llm model.LLM) (EvalResult, error) {
+	agent, err := newAuditorAgent(repo, llm, evalTools(repo, ds))
+	if err != nil {
+		return EvalResult{}, err
+	}
+	// With an agent set, processSingleIssue takes the agent path whatever
+	// decision_engine is configured.
+	audit := &repoAudit{repo: repo, agent: agent}
+
+	name := llm.Name()
+	callsBefore := llmCalls.Value(name, "success") + llmCalls.Value(name, "error")
+	inputBefore := llmTokens.Value(name, "prompt")
+	outputBefore := llmTokens.Value(name, "candidates")
+
+	resetRunRecords()
+	items := make([]workItem, len(ds.Examples))
+	for i, ex := range ds.Examples {
+		items[i] = workItem{number: ex.Number, process: func(ctx context.Context, n int) processSingleResult {
+			return processSingleIssue(ctx, audit, n)
+		}}
+	}
+	summary := repoSummary{repo: repo.FullName()}
+	processQueue(withRepository(ctx, repo), &summary, items, nil)
+	if summary.err != nil {
+		return EvalResult{}, summary.err
+	}
+
+	recordsLock.Lock()
+	records := map[int]AuditRecord{}
+	for _, rec := range runRecords {
+		records[rec.Number] = rec
+	}
+	recordsLock.Unlock()
+
+	res := scoreEval(ds, records)
+	res.Model = name
+	res.ModelCalls = int(llmCalls.Value(name, "success") + llmCalls.Value(name, "error") - callsBefore)
+	res.InputTokens = int(llmTokens.Value(name, "prompt") - inputBefore)
+	res.OutputTokens = int(llmTokens.Value(name, "candidates") - outputBefore)
+	if price, ok := ds.Pricing[name]; ok {
+		cost := float64(res.InputTokens)/1e6*price.Input + float64(res.OutputTokens)/1e6*price.Output
+		res.CostUSD = &cost
+	}
+	return res, nil
+}
+
+// scoreEval compares the audit records of a run with the dataset labels.
+func scoreEval(ds *EvalDataset, records map[int]AuditRecord) EvalResult {
+	res := EvalResult{Examples: len(ds.Examples), Confusion: map[string]map[string]int{}}
+	var latencies []int64
+
+	for _, ex := range ds.Examples {
+		rec := records[ex.Number]
+		predicted, tools := evalOutcome(rec)
+		if rec.Error != "" {
+			res.Errors++
+		}
+		latencies = append(latencies, rec.DurationMS)
+
+		if res.Confusion[ex.ExpectedVerdict] == nil {
+			res.Confusion[ex.ExpectedVerdict] = map[string]int{}
+		}
+		res.Confusion[ex.ExpectedVerdict][predicted]++
+
+		expectedTools := make([]string, len(ex.ExpectedTools))
+		for i, c := range ex.ExpectedTools {
+			expectedTools[i] = c.String()
+		}
+		sort.Strings(expectedTools)
+
+		verdictOK := predicted == ex.ExpectedVerdict
+		toolsOK := strings.Join(tools, ",") == strings.Join(expectedTools, ",")
+		if verdictOK {
+			res.VerdictCorrect++
+		}
+		if toolsOK {
+			res.ToolCorrect++
+		}
+		if !verdictOK || !toolsOK {
+			res.Misses = append(res.Misses, EvalMiss{
+				Name:          ex.Name,
+				Number:        ex.Number,
+				Expected:      ex.ExpectedVerdict,
+				Predicted:     predicted,
+				ExpectedTools: expectedTools,
+				Tools:         tools,
+				Error:         rec.Error,
+			})
+		}
+	}
+
+	if res.Examples > 0 {
+		res.VerdictAccuracy = float64(res.VerdictCorrect) / float64(res.Examples)
+		res.ToolAccuracy = float64(res.ToolCorrect) / float64(res.Examples)
+	}
+	res.LatencyMeanMS, res.LatencyP50MS, res.LatencyP95MS = latencyStats(latencies)
+	return res
+}
+
+// evalOutcome derives the eval verdict and the sorted mutating tool calls
+// from an audit record.
+func evalOutcome(rec AuditRecord) (string, []string) {
+	tools := []string{}
+	closed := false
+	for _, call := range rec.ToolCalls {
+		if call.Tool == "get_issue_state" {
+			continue
+		}
+		c := EvalToolCall{Tool: call.Tool}
+		if args, ok := call.Args.(LabelTargetArgs); ok {
+			c.Label = args.LabelName
+		}
+		tools = append(tools, c.String())
+		if call.Tool == "close_as_stale" {
+			closed = true
+		}
+	}
+	sort.Strings(tools)
+
+	verdict := rec.Verdict
+	switch {
+	case verdict == VerdictStale && closed:
+		verdict = EvalClose
+	case verdict == "":
+		verdict = EvalNone
+	}
+	return verdict, tools
+}
+
+func latencyStats(ms []int64) (mean, p50, p95 int64) {
+	if len(ms) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]int64(nil), ms...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum int64
+	for _, v := range sorted {
+		sum += v
+	}
+	rank := func(p float64) int64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return sorted[max(i, 0)]
+	}
+	return sum / int64(len(sorted)), rank(0.50), rank(0.95)
+}
+
+// checkEvalThresholds returns a message for every threshold a model
+// crosses.
+func checkEvalThresholds(ds *EvalDataset, results, baseline []EvalResult) []string {
+	t := ds.Thresholds
+	previous := map[string]EvalResult{}
+	for _, b := range baseline {
+		previous[b.Model] = b
+	}
+
+	var failures []string
+	for _, r := range results {
+		if r.VerdictAccuracy < t.MinVerdictAccuracy {
+			failures = append(failures, fmt.Sprintf("%s: verdict accuracy %.3f below %.3f", r.Model, r.VerdictAccuracy, t.MinVerdictAccuracy))
+		}
+		if r.ToolAccuracy < t.MinToolAccuracy {
+			failures = append(failures, fmt.Sprintf("%s: tool accuracy %.3f below %.3f", r.Model, r.ToolAccuracy, t.MinToolAccuracy))
+		}
+		if b, ok := previous[r.Model]; ok && t.MaxAccuracyDrop > 0 {
+			if drop := b.VerdictAccuracy - r.VerdictAccuracy; drop > t.MaxAccuracyDrop {
+				failures = append(failures, fmt.Sprintf("%s: verdict accuracy dropped %.3f from the baseline (max %.3f)", r.Model, drop, t.MaxAccuracyDrop))
+			}
+		}
+	}
+	return failures
+}
+
+// ---------------- Report ----------------
+
+func renderEvalMarkdown(results []EvalResult) string {
+	var b strings.Builder
+	b.WriteString("## Stale Bot eval\n\n")
+	b.WriteString("| Model | Verdict accuracy | Tool accuracy | Errors | Model calls | Tokens (in/out) | Cost (USD) | Latency mean / p50 / p95 |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, r := range results {
+		cost := "n/a"
+		if r.CostUSD != nil {
+			cost = fmt.Sprintf("%.4f", *r.CostUSD)
+		}
+		fmt.Fprintf(&b, "| %s | %.1f%% (%d/%d) | %.1f%% (%d/%d) | %d | %d | %d / %d | %s | %.1fs / %.1fs / %.1fs |\n",
+			r.Model,
+			100*r.VerdictAccuracy, r.VerdictCorrect, r.Examples,
+			100*r.ToolAccuracy, r.ToolCorrect, r.Examples,
+			r.Errors, r.ModelCalls, r.InputTokens, r.OutputTokens, cost,
+			float64(r.LatencyMeanMS)/1000, float64(r.LatencyP50MS)/1000, float64(r.LatencyP95MS)/1000,
+		)
+	}
+
+	columns := append(append([]string(nil), evalVerdicts...), EvalNone)
+	for _, r := range results {
+		fmt.Fprintf(&b, "\n### %s: confusion matrix (rows expected, columns predicted)\n\n", r.Model)
+		b.WriteString("| | " + strings.Join(columns, " | ") + " |\n")
+		b.WriteString("|---" + strings.Repeat("|---", len(columns)) + "|\n")
+		for _, expected := range evalVerdicts {
+			b.WriteString("| **" + expected + "** ")
+			for _, predicted := range columns {
+				fmt.Fprintf(&b, "| %d ", r.Confusion[expected][predicted])
+			}
+			b.WriteString("|\n")
+		}
+
+		if len(r.Misses) > 0 {
+			b.WriteString("\n| Example | Expected | Predicted | Expected tools | Tools |\n|---|---|---|---|---|\n")
+			for _, m := range r.Misses {
+				fmt.Fprintf(&b, "| %s (#%d) | %s | %s | %s | %s |\n",
+					m.Name, m.Number, m.Expected, m.Predicted,
+					strings.Join(m.ExpectedTools, ", "), strings.Join(m.Tools, ", "))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeGitHub is an in-process GitHub for end-to-end runs without network
+// access. It serves the REST endpoints the tools call (search,
+// collaborators, comments, labels, issue PATCH) and the GraphQL issue
+// queries from in-memory issues, and applies every write to them so a run's
+// outcome can be checked afterwards. Pull requests are not modelled.
+type FakeGitHub struct {
+	Owner string
+	Repo  string
+
+	server *httptest.Server
+
+	lock        sync.Mutex
+	maintainers []string
+	issues      map[int]*FakeIssue
+	requests    []string
+}
+
+// FakeIssue is one issue held by FakeGitHub. Issue is what the GraphQL
+// queries return; bot writes are recorded in it as they happen.
+type FakeIssue struct {
+	Number      int
+	State       string // "open" or "closed"
+	StateReason string
+	Issue       GraphQLIssue
+}
+
+// NewFakeGitHub starts a fake serving owner/repo with the given maintainers
+// (collaborators with push access) and issues.
+func NewFakeGitHub(owner, repo string, maintainers []string, issues []*FakeIssue) *FakeGitHub {
+	f := &FakeGitHub{
+		Owner:       owner,
+		Repo:        repo,
+		maintainers: maintainers,
+		issues:      map[int]*FakeIssue{},
+	}
+	for _, issue := range issues {
+		if issue.State == "" {
+			issue.State = "open"
+		}
+		f.issues[issue.Number] = issue
+	}
+
+	prefix := fmt.Sprintf("/repos/%s/%s", owner, repo)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search/issues", f.searchIssues)
+	mux.HandleFunc("GET "+prefix+"/collaborators", f.collaborators)
+	mux.HandleFunc("GET "+prefix+"/issues/{number}/comments", f.listComments)
+	mux.HandleFunc("POST "+prefix+"/issues/{number}/comments", f.createComment)
+	mux.HandleFunc("POST "+prefix+"/issues/{number}/labels", f.addLabels)
+	mux.HandleFunc("DELETE "+prefix+"/issues/{number}/labels/{name}", f.removeLabel)
+	mux.HandleFunc("PATCH "+prefix+"/issues/{number}", f.updateIssue)
+	mux.HandleFunc("POST /graphql", f.graphql)
+
+	f.server = httptest.NewServer(f.authenticated(mux))
+	return f
+}
+
+// URL is the REST base; GraphQL is served from URL + "/graphql".
+func (f *FakeGitHub) URL() string {
+	return f.server.URL
+}
+
+func (f *FakeGitHub) Close() {
+	f.server.Close()
+}
+
+// Issue returns a copy of the current state of an issue.
+func (f *FakeGitHub) Issue(number int) (FakeIssue, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	issue, ok := f.issues[number]
+	if !ok {
+		return FakeIssue{}, false
+	}
+	return *issue, true
+}
+
+// Requests lists every request served, as "METHOD /path".
+func (f *FakeGitHub) Requests() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// ---------------- Plumbing ----------------
+
+// authenticated rejects requests without credentials, records the request
+// and sets the rate-limit headers the client tracks.
+func (f *FakeGitHub) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.lock.Unlock()
+
+		resource := "core"
+		switch {
+		case r.URL.Path == "/graphql":
+			resource = "graphql"
+		case strings.HasPrefix(r.URL.Path, "/search/"):
+			resource = "search"
+		}
+		w.Header().Set("X-RateLimit-Resource", resource)
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+
+		if r.Header.Get("Authorization") == "" {
+			writeFakeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Requires authentication"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// issueFor resolves the {number} path value; the caller holds f.lock.
+func (f *FakeGitHub) issueFor(w http.ResponseWriter, r *http.Request) *FakeIssue {
+	n, _ := strconv.Atoi(r.PathValue("number"))
+	issue, ok := f.issues[n]
+	if !ok {
+		writeFakeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return nil
+	}
+	return issue
+}
+
+// lastActivity is the issue's updatedAt: the latest of its creation,
+// comments, edits and events.
+func (i *FakeIssue) lastActivity() time.Time {
+	latest := i.Issue.CreatedAt
+	for _, c := range i.Issue.Comments.Nodes {
+		if c.CreatedAt.After(latest) {
+			latest = c.CreatedAt
+		}
+	}
+	for _, e := range i.Issue.UserContentEdits.Nodes {
+		if e.EditedAt.After(latest) {
+			latest = e.EditedAt
+		}
+	}
+	for _, t := range i.Issue.TimelineItems.Nodes {
+		if t.CreatedAt.After(latest) {
+			latest = t.CreatedAt
+		}
+	}
+	return latest
+}
+
+func (i *FakeIssue) lastEdited() *time.Time {
+	var latest *time.Time
+	for _, e := range i.Issue.UserContentEdits.Nodes {
+		if latest == nil || e.EditedAt.After(*latest) {
+			t := e.EditedAt
+			latest = &t
+		}
+	}
+	return latest
+}
+
+// ---------------- REST ----------------
+
+// searchIssues supports the query the bot sends:
+// "repo:OWNER/REPO is:issue state:open created:<TIMESTAMP". Every match
+// is returned on the first page.
+func (f *FakeGitHub) searchIssues(w http.ResponseWriter, r *http.Request) {
+	var createdBefore time.Time
+	kind := "issue"
+	for _, term := range strings.Fields(r.URL.Query().Get("q")) {
+		switch {
+		case strings.HasPrefix(term, "repo:"):
+			if term != "repo:"+f.Owner+"/"+f.Repo {
+				writeFakeJSON(w, http.StatusOK, map[string]any{"total_count": 0, "items": []any{}})
+				return
+			}
+		case strings.HasPrefix(term, "is:"):
+			kind = strings.TrimPrefix(term, "is:")
+		case strings.HasPrefix(term, "created:<"):
+			createdBefore, _ = time.Parse(time.RFC3339, strings.TrimPrefix(term, "created:<"))
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	items := []any{}
+	if kind == "issue" && r.URL.Query().Get("page") <= "1" {
+		for _, n := range f.sortedNumbers() {
+			issue := f.issues[n]
+			if issue.State == "open" && issue.Issue.CreatedAt.Before(createdBefore) {
+				items = append(items, map[string]any{"number": n})
+			}
+		}
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]any{"total_count": len(items), "items": items})
+}
+
+func (f *FakeGitHub) sortedNumbers() []int {
+	numbers := make([]int, 0, len(f.issues))
+	for n := range f.issues {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func (f *FakeGitHub) collaborators(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	users := []map[string]string{}
+	for _, login := range f.maintainers {
+		users = append(users, map[string]string{"login": login})
+	}
+	writeFakeJSON(w, http.StatusOK, users)
+}
+
+func (f *FakeGitHub) listComments(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	issue := f.issueFor(w, r)
+	if issue == nil {
+		return
+	}
+	comments := []map[string]any{}
+	for _, c := range issue.Issue.Comments.Nodes {
+		comments = append(comments, map[string]any{
+			"user":       map[string]string{"login": LoginOf(c.Author)},
+			"body":       c.Body,
+			"created_at": c.CreatedAt,
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, comments)
+}
+
+func (f *FakeGitHub) createComment(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Body == "" {
+		writeFakeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "body is required"})
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	issue := f.issueFor(w, r)
+	if issue == nil {
+		return
+	}
+	comment := &GraphQLComment{Author: &GraphQLActor{Login: BOT_NAME}, Body: body.Body, CreatedAt: time.Now().UTC()}
+	issue.Issue.Comments.Nodes = append(issue.Issue.Comments.Nodes, comment)
+	writeFakeJSON(w, http.StatusCreated, map[string]any{
+		"id":         len(issue.Issue.Comments.Nodes),
+		"body":       comment.Body,
+		"created_at": comment.CreatedAt,
+	})
+}
+
+// addLabels accepts both a bare array and {"labels": [...]}.
+func (f *FakeGitHub) addLabels(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	var names []string
+	if err := json.Unmarshal(raw, &names); err != nil {
+		var wrapped struct {
+			Labels []string `json:"labels"`
+		}
+		json.Unmarshal(raw, &wrapped)
+		names = wrapped.Labels
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	issue := f.issueFor(w, r)
+	if issue == nil {
+		return
+	}
+	now := time.Now().UTC()
+	for _, name := range names {
+		if containsString(issue.Issue.LabelNames(), name) {
+			continue
+		}
+		issue.Issue.Labels.Nodes = append(issue.Issue.Labels.Nodes, &GraphQLLabel{Name: name})
+		issue.Issue.TimelineItems.Nodes = append(issue.Issue.TimelineItems.Nodes, &GraphQLTimelineItem{
+			Typename:  "LabeledEvent",
+			CreatedAt: now,
+			Actor:     &GraphQLActor{Login: BOT_NAME},
+			Label:     &GraphQLLabel{Name: name},
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, fakeLabelList(issue))
+}
+
+func (f *FakeGitHub) removeLabel(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	issue := f.issueFor(w, r)
+	if issue == nil {
+		return
+	}
+	name := r.PathValue("name")
+	kept := issue.Issue.Labels.Nodes[:0]
+	found := false
+	for _, l := range issue.Issue.Labels.Nodes {
+		if l.Name == name {
+			found = true
+			continue
+		}
+		kept = append(kept, l)
+	}
+	if !found {
+		writeFakeJSON(w, http.StatusNotFound, map[string]string{"message": "Label does not exist"})
+		return
+	}
+	issue.Issue.Labels.Nodes = kept
+	writeFakeJSON(w, http.StatusOK, fakeLabelList(issue))
+}
+
+func fakeLabelList(issue *FakeIssue) []map[string]string {
+	labels := []map[string]string{}
+	for _, name := range issue.Issue.LabelNames() {
+		labels = append(labels, map[string]string{"name": name})
+	}
+	return labels
+}
+
+func (f *FakeGitHub) updateIssue(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		State       string `json:"state"`
+		StateReason string `json:"state_reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	issue := f.issueFor(w, r)
+	if issue == nil {
+		return
+	}
+	if body.State != "" {
+		issue.State = body.State
+		issue.StateReason = body.StateReason
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]any{"number": issue.Number, "state": issue.State})
+}
+
+// ---------------- GraphQL ----------------
+
+var fakeBatchAlias = regexp.MustCompile(`(issue\d+): issue\(number: (\d+)\)`)
+
+// graphql answers the issue queries by their shape: batched aliases, a
+// single issue, and the repository.issues candidate listing. History is
+// always returned complete, so the pagination queries are never needed.
+func (f *FakeGitHub) graphql(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	if owner, name := req.Variables["owner"], req.Variables["name"]; owner != f.Owner || name != f.Repo {
+		writeFakeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"repository": nil}})
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var data map[string]any
+	var errs []GraphQLError
+	switch {
+	case fakeBatchAlias.MatchString(req.Query):
+		repo := map[string]any{}
+		for _, m := range fakeBatchAlias.FindAllStringSubmatch(req.Query, -1) {
+			n, _ := strconv.Atoi(m[2])
+			if issue, ok := f.issues[n]; ok {
+				repo[m[1]] = issue.Issue
+			} else {
+				repo[m[1]] = nil
+				errs = append(errs, fakeNotFound(n, m[1]))
+			}
+		}
+		data = map[string]any{
+			"rateLimit":  GraphQLRateLimit{Cost: 1, Remaining: 4999, ResetAt: time.Now().Add(time.Hour)},
+			"repository": repo,
+		}
+
+	case strings.Contains(req.Query, "issue(number: $number)"):
+		number, _ := req.Variables["number"].(float64)
+		n := int(number)
+		if issue, ok := f.issues[n]; ok {
+			data = map[string]any{"repository": map[string]any{"issue": issue.Issue}}
+		} else {
+			data = map[string]any{"repository": map[string]any{"issue": nil}}
+			errs = append(errs, fakeNotFound(n, "issue"))
+		}
+
+	case strings.Contains(req.Query, "issues("):
+		data = map[string]any{"repository": map[string]any{"issues": f.issueList(req.Variables)}}
+
+	default:
+		writeFakeJSON(w, http.StatusOK, map[string]any{
+			"errors": []GraphQLError{{Message: "query not supported by the fake GitHub"}},
+		})
+		return
+	}
+
+	resp := map[string]any{"data": data}
+	if len(errs) > 0 {
+		resp["errors"] = errs
+	}
+	writeFakeJSON(w, http.StatusOK, resp)
+}
+
+func fakeNotFound(n int, alias string) GraphQLError {
+	return GraphQLError{
+		Type:    "NOT_FOUND",
+		Message: fmt.Sprintf("Could not resolve to an Issue with the number of %d.", n),
+		Path:    []any{"repository", alias},
+	}
+}
+
+// issueList answers the candidate query in a single page; the caller holds
+// f.lock.
+func (f *FakeGitHub) issueList(variables map[string]any) map[string]any {
+	var since time.Time
+	if s, ok := variables["since"].(string); ok {
+		since, _ = time.Parse(time.RFC3339, s)
+	}
+	var labels []string
+	if ls, ok := variables["labels"].([]any); ok {
+		for _, l := range ls {
+			labels = append(labels, fmt.Sprint(l))
+		}
+	}
+
+	var open []*FakeIssue
+	for _, n := range f.sortedNumbers() {
+		issue := f.issues[n]
+		if issue.State != "open" || issue.lastActivity().Before(since) {
+			continue
+		}
+		if len(labels) > 0 && !hasAnyLabel(issue.Issue.LabelNames(), labels) {
+			continue
+		}
+		open = append(open, issue)
+	}
+	desc := variables["direction"] == "DESC"
+	sort.SliceStable(open, func(i, j int) bool {
+		if desc {
+			return open[i].lastActivity().After(open[j].lastActivity())
+		}
+		return open[i].lastActivity().Before(open[j].lastActivity())
+	})
+
+	nodes := []map[string]any{}
+	for _, issue := range open {
+		nodes = append(nodes, map[string]any{
+			"number":       issue.Number,
+			"updatedAt":    issue.lastActivity(),
+			"lastEditedAt": issue.lastEdited(),
+			"author":       issue.Issue.Author,
+			"labels":       map[string]any{"nodes": issue.Issue.Labels.Nodes},
+		})
+	}
+	return map[string]any{
+		"pageInfo": GraphQLPageInfo{},
+		"nodes":    nodes,
+	}
+}
+
+func hasAnyLabel(have, want []string) bool {
+	for _, w := range want {
+		if containsString(have, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Golden files pin the timeline replay (buildHistoryTimeline,
+// replayHistoryToFindState and the get_issue_state output) to recorded
+// behavior. Each case is a directory under testdata/golden holding
+// input.json, the issue exactly as the GraphQL issue query returns it plus
+// the clock and maintainers, and the expected outputs:
+//
+//	timeline.golden.json     history, stale label events, last bot alert
+//	state.golden.json        IssueState
+//	issue_state.golden.json  get_issue_state result
+//
+// TestGolden compares every case against its goldens; with
+//
+//	go test -run TestGolden -update
+//
+// the goldens are rewritten from the current code instead, for review in
+// the diff.
+
+var update = flag.Bool("update", false, "rewrite the golden files from the current code instead of checking them")
+
+// GoldenInput is a case's input.json.
+type GoldenInput struct {
+	Description string    `json:"description,omitempty"`
+	Now         time.Time `json:"now"`
+	Number      int       `json:"number"`
+	Maintainers []string  `json:"maintainers"`
+
+	// Issue is the repository.issue object of the GraphQL response.
+	Issue GraphQLIssue `json:"issue"`
+}
+
+// goldenTimeline is the rendered result of buildHistoryTimeline.
+type goldenTimeline struct {
+	History          []TimelineEvent `json:"history"`
+	StaleLabelEvents []time.Time     `json:"stale_label_events"`
+	LastBotAlert     *time.Time      `json:"last_bot_alert"`
+}
+
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "input.json"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatal("no golden cases in testdata/golden")
+	}
+
+	// Labels, thresholds and the bot identity come from the defaults, so
+	// goldens do not depend on the local config file or environment.
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "golden", "repo"
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	repo := Repositories[0]
+
+	for _, input := range inputs {
+		caseDir := filepath.Dir(input)
+		t.Run(filepath.Base(caseDir), func(t *testing.T) {
+			outputs, err := goldenOutputs(repo, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, got := range outputs {
+				path := filepath.Join(caseDir, name)
+				if *update {
+					if err := os.WriteFile(path, got, 0o644); err != nil {
+						t.Error(err)
+					}
+					continue
+				}
+
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Errorf("%v; run with -update to create it", err)
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					line, gotLine, wantLine := firstDiff(string(got), string(want))
+					t.Errorf("%s differs at line %d:\n got: %s\nwant: %s", name, line, gotLine, wantLine)
+				}
+			}
+		})
+	}
+}
+
+// goldenOutputs replays one input and renders each golden file.
+func goldenOutputs(repo *Repository, path string) (map[string][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var in GoldenInput
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if in.Now.IsZero() {
+		return nil, fmt.Errorf("%s: now is required", path)
+	}
+
+	saved := clock
+	clock = func() time.Time { return in.Now }
+	defer func() { clock = saved }()
+
+	history, labelEvents, lastBotAlert := buildHistoryTimeline(&in.Issue, repo.StaleLabelName)
+	state := replayHistoryToFindState(history, in.Maintainers, LoginOf(in.Issue.Author))
+	analysis := repo.analyzeIssueData(in.Number, &in.Issue, in.Maintainers)
+
+	outputs := map[string]any{
+		"timeline.golden.json":    goldenTimeline{History: history, StaleLabelEvents: labelEvents, LastBotAlert: lastBotAlert},
+		"state.golden.json":       state,
+		"issue_state.golden.json": analysis.toMap(),
+	}
+	rendered := map[string][]byte{}
+	for name, v := range outputs {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", name, err)
+		}
+		rendered[name] = append(b, '\n')
+	}
+	return rendered, nil
+}
+
+// firstDiff returns the first line (1-based) where got and want differ.
+func firstDiff(got, want string) (int, string, string) {
+	g := strings.Split(got, "\n")
+	w := strings.Split(want, "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl {
+			return i + 1, strings.TrimSpace(gl), strings.TrimSpace(wl)
+		}
+	}
+	return 0, "", ""
+}
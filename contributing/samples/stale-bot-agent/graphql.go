@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ---------------- Issue Query Types ----------------
+
+// GraphQLActor is a user or bot. GitHub returns null for deleted accounts,
+// so actors are always referenced through pointers.
+type GraphQLActor struct {
+	Login string `json:"login"`
+}
+
+// LoginOf returns the actor's login, or "" for a null actor.
+func LoginOf(a *GraphQLActor) string {
+	if a == nil {
+		return ""
+	}
+	return a.Login
+}
+
+type GraphQLLabel struct {
+	Name string `json:"name"`
+}
+
+type GraphQLComment struct {
+	Author       *GraphQLActor `json:"author"`
+	Body         string        `json:"body"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	LastEditedAt *time.Time    `json:"lastEditedAt"`
+}
+
+type GraphQLEdit struct {
+	Editor   *GraphQLActor `json:"editor"`
+	EditedAt time.Time     `json:"editedAt"`
+}
+
+// GraphQLTimelineItem covers the LabeledEvent, RenamedTitleEvent and
+// ReopenedEvent fragments of the issue query; Label is only set for
+// LabeledEvent.
+type GraphQLTimelineItem struct {
+	Typename  string        `json:"__typename"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Actor     *GraphQLActor `json:"actor"`
+	Label     *GraphQLLabel `json:"label"`
+}
+
+// GraphQLPageInfo holds the cursors of a connection. Labels are paged
+// forwards, everything else backwards from the most recent entries.
+type GraphQLPageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	EndCursor       string `json:"endCursor"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor"`
+}
+
+// GraphQLIssue is the repository.issue object returned by the issue query.
+// List entries may be null in GraphQL, hence the pointer slices.
+type GraphQLIssue struct {
+	Author    *GraphQLActor `json:"author"`
+	CreatedAt time.Time     `json:"createdAt"`
+
+	Labels struct {
+		PageInfo GraphQLPageInfo `json:"pageInfo"`
+		Nodes    []*GraphQLLabel `json:"nodes"`
+	} `json:"labels"`
+
+	Comments struct {
+		PageInfo GraphQLPageInfo   `json:"pageInfo"`
+		Nodes    []*GraphQLComment `json:"nodes"`
+	} `json:"comments"`
+
+	UserContentEdits struct {
+		PageInfo GraphQLPageInfo `json:"pageInfo"`
+		Nodes    []*GraphQLEdit  `json:"nodes"`
+	} `json:"userContentEdits"`
+
+	TimelineItems struct {
+		PageInfo GraphQLPageInfo        `json:"pageInfo"`
+		Nodes    []*GraphQLTimelineItem `json:"nodes"`
+	} `json:"timelineItems"`
+
+	// HistoryTruncated is set when pagination stopped at the page cap
+	// before every relevant event was found.
+	HistoryTruncated bool `json:"-"`
+}
+
+// ---------------- Field Selections ----------------
+
+// Selections shared by the issue query and the pagination queries.
+const (
+	labelFields = `
+        pageInfo { hasNextPage endCursor }
+        nodes { name }
+      `
+
+	commentFields = `
+        pageInfo { hasPreviousPage startCursor }
+        nodes {
+          author { login }
+          body
+          createdAt
+          lastEditedAt
+        }
+      `
+
+	editFields = `
+        pageInfo { hasPreviousPage startCursor }
+        nodes {
+          editor { login }
+          editedAt
+        }
+      `
+
+	timelineItemTypes = `[LABELED_EVENT, RENAMED_TITLE_EVENT, REOPENED_EVENT]`
+
+	timelineFields = `
+        pageInfo { hasPreviousPage startCursor }
+        nodes {
+          __typename
+          ... on LabeledEvent {
+            createdAt
+            actor { login }
+            label { name }
+          }
+          ... on RenamedTitleEvent {
+            createdAt
+            actor { login }
+          }
+          ... on ReopenedEvent {
+            createdAt
+            actor { login }
+          }
+        }
+      `
+)
+
+// issueFragment selects everything the analysis reads from an issue. The
+// single-issue and the batched queries both spread it, so they must declare
+// the $commentLimit, $editLimit and $timelineLimit variables.
+const issueFragment = `
+fragment IssueFields on Issue {
+  author { login }
+  createdAt
+  labels(first: 100) {` + labelFields + `}
+
+  comments(last: $commentLimit) {` + commentFields + `}
+
+  userContentEdits(last: $editLimit) {` + editFields + `}
+
+  timelineItems(itemTypes: ` + timelineItemTypes + `, last: $timelineLimit) {` + timelineFields + `}
+}
+`
+
+// LabelNames returns the names of the issue's labels.
+func (i *GraphQLIssue) LabelNames() []string {
+	return labelNames(i.Labels.Nodes)
+}
+
+func labelNames(nodes []*GraphQLLabel) []string {
+	var names []string
+	for _, l := range nodes {
+		if l != nil {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
+
+// ---------------- Errors ----------------
+
+// GraphQLError is one entry of a GraphQL response's "errors" array.
+type GraphQLError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Path    []any  `json:"path"`
+}
+
+func (e GraphQLError) String() string {
+	var b strings.Builder
+	if e.Type != "" {
+		b.WriteString(e.Type + ": ")
+	}
+	b.WriteString(e.Message)
+	if len(e.Path) > 0 {
+		parts := make([]string, len(e.Path))
+		for i, p := range e.Path {
+			parts[i] = fmt.Sprint(p)
+		}
+		b.WriteString(" (at " + strings.Join(parts, ".") + ")")
+	}
+	return b.String()
+}
+
+// GraphQLErrors reports every error of a GraphQL response. Partial is set
+// when GitHub still returned data alongside the errors.
+type GraphQLErrors struct {
+	Errors  []GraphQLError
+	Partial bool
+}
+
+func (e *GraphQLErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, ge := range e.Errors {
+		msgs[i] = ge.String()
+	}
+	msg := fmt.Sprintf("GraphQL Error: %s", strings.Join(msgs, "; "))
+	if e.Partial {
+		msg += " (partial data returned)"
+	}
+	return msg
+}
+
+// ErrNotFound is returned when a repository, issue or pull request is
+// missing from a GraphQL response. IsNotFound reports it like a REST 404.
+var ErrNotFound = errors.New("not found")
+
+// graphQLNotFound is the error type GitHub reports for a missing node.
+const graphQLNotFound = "NOT_FOUND"
+
+// graphQLDataError interprets the error of a graphQLQuery whose caller can
+// use partial data. Errors that came with data are logged and dropped, so
+// the caller keeps what was returned and reports missing nodes with
+// notFoundError. A response without data whose errors are all NOT_FOUND
+// becomes ErrNotFound; anything else is returned unchanged.
+func graphQLDataError(ctx context.Context, err error, attrs ...any) error {
+	var gqlErrs *GraphQLErrors
+	if !errors.As(err, &gqlErrs) {
+		return err
+	}
+	if gqlErrs.Partial {
+		for _, e := range gqlErrs.Errors {
+			slog.WarnContext(ctx, "Partial GraphQL data", append(attrs, "error", e)...)
+		}
+		return nil
+	}
+	for _, e := range gqlErrs.Errors {
+		if e.Type != graphQLNotFound {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrNotFound, err)
+}
+
+// notFoundError reports a node that is missing from a query's data, e.g.
+// notFoundError("issue #%d", 12).
+func notFoundError(format string, args ...any) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrNotFound)
+}
+
+// ---------------- Request ----------------
+
+// graphQLQuery runs a query and decodes its "data" into data. When the
+// response carries errors a *GraphQLErrors is returned; if data was also
+// present it has already been decoded, so callers may choose to use it.
+func graphQLQuery(ctx context.Context, query string, variables map[string]any, data any) error {
+	payload := map[string]any{
+		"query":     query,
+		"variables": variables,
+	}
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []GraphQLError  `json:"errors"`
+	}
+	if err := PostJSONRequest(ctx, GitHubGraphQLURL, payload, &resp); err != nil {
+		return err
+	}
+
+	hasData := len(resp.Data) > 0 && string(resp.Data) != "null"
+	if hasData {
+		if err := json.Unmarshal(resp.Data, data); err != nil {
+			return fmt.Errorf("decoding GraphQL data: %w", err)
+		}
+	}
+
+	if len(resp.Errors) > 0 {
+		return &GraphQLErrors{Errors: resp.Errors, Partial: hasData}
+	}
+	if !hasData {
+		return errors.New("GraphQL response contained no data")
+	}
+	return nil
+}
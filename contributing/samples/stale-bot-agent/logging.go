@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logging goes through log/slog. Attributes attached to a context with
+// withLogAttrs (run_id, repository, issue_number) are added to every line
+// logged with that context, so one issue's trail can be filtered out of the
+// interleaved output of concurrent workers. Every line is redacted before
+// it is written.
+
+// logLevel is the minimum level written; set from the config.
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr, "text")))
+}
+
+// configureLogging installs the handler for format ("text" or "json") and
+// the minimum level ("debug", "info", "warn" or "error").
+func configureLogging(format, level string) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr, format)))
+	return nil
+}
+
+func newLogHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if format == "json" {
+		return contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// fatal logs an error and exits, like log.Fatalf did.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// ---------------- Context Attributes ----------------
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log lines carry the given key/value
+// pairs in addition to those already attached.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	attrs := append([]slog.Attr(nil), logAttrsFrom(ctx)...)
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
+}
+
+func logAttrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the context attributes to every record and redacts
+// the message and all attributes.
+type contextHandler struct {
+	next slog.Handler
+}
+
+func (h contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	for _, a := range logAttrsFrom(ctx) {
+		out.AddAttrs(redactAttr(a))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return contextHandler{h.next.WithAttrs(out)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.next.WithGroup(name)}
+}
+
+// ---------------- Redaction ----------------
+
+const redacted = "[REDACTED]"
+
+var (
+	secrets     []string
+	secretsLock sync.RWMutex
+
+	// Credentials that were never registered still match these: GitHub
+	// token formats and Authorization header values.
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+		regexp.MustCompile(`(?i)\b((?:token|bearer)\s+)[A-Za-z0-9_.\-]{20,}`),
+	}
+)
+
+// registerSecret makes every later log line replace s with [REDACTED].
+func registerSecret(s string) {
+	if len(s) < 8 {
+		return
+	}
+	secretsLock.Lock()
+	secrets = append(secrets, s)
+	secretsLock.Unlock()
+}
+
+func redact(s string) string {
+	secretsLock.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsLock.RUnlock()
+
+	s = secretPatterns[0].ReplaceAllString(s, redacted)
+	return secretPatterns[1].ReplaceAllString(s, "${1}"+redacted)
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(a.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are rendered, which also keeps a secret
+		// inside a struct from reaching the JSON encoder.
+		return slog.String(a.Key, redact(fmt.Sprint(a.Value.Any())))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		attrs := make([]any, len(group))
+		for i, ga := range group {
+			attrs[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, attrs...)
+	}
+	return a
+}
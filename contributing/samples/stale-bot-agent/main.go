@@ -2,19 +2,24 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/adk/agent"
 	"google.golang.org/adk/agent/llmagent"
 	"google.golang.org/adk/artifact"
 	"google.golang.org/adk/memory"
+	"google.golang.org/adk/model"
 	"google.golang.org/adk/model/gemini"
 	"google.golang.org/adk/runner"
 	"google.golang.org/adk/session"
@@ -29,9 +34,32 @@ const (
 	UserID  = "stale_bot_user"
 )
 
-var rootAgent agent.Agent
+var ruleClassifier CommentClassifier
+
+// issueFilter, when set, limits processing to these numbers (-issue).
+var issueFilter map[int]bool
 var PROMPT_TEMPLATE string
-var geminiModel = getEnv("GEMINI_MODEL", "gemini-2.5-pro")
+var geminiModel string
+
+// repoAudit bundles a repository with the agent whose instruction and tools
+// are bound to it. Without an agent, issues go through the rules engine.
+type repoAudit struct {
+	repo  *Repository
+	agent agent.Agent
+}
+
+// repoSummary holds the per-repository totals shown in the final report.
+type repoSummary struct {
+	repo           string
+	issuesFound    int
+	pullsFound     int
+	processed      int
+	searchAPICalls int
+	issueAPICalls  int
+	processingTime time.Duration
+	duration       time.Duration
+	err            error
+}
 
 // processSingleResult holds the return values for processSingleIssue
 type processSingleResult struct {
@@ -45,75 +73,141 @@ type ToolResult struct {
 	Message string `json:"message,omitempty"`
 }
 
-// processSingleIssue processes a single GitHub issue using the AI agent.
-func processSingleIssue(ctx context.Context, issueNumber int) processSingleResult {
+// processSingleIssue processes a single GitHub issue using the audit's agent,
+// or the rules engine when it has none.
+func processSingleIssue(ctx context.Context, audit *repoAudit, issueNumber int) processSingleResult {
+	if IssueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, IssueTimeout)
+		defer cancel()
+	}
+	ctx = withLogAttrs(ctx, "issue_number", issueNumber)
+	ctx, rec := startIssueAudit(ctx, audit.repo, "issue", issueNumber)
+
 	startTime := time.Now()
-	startAPICalls := GetAPICallCount()
-	log.Printf("Processing Issue #%d...", issueNumber)
+	slog.InfoContext(ctx, "Processing issue")
 	res := processSingleResult{}
 
 	// Error handling block (equivalent to try...except)
 	func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Error processing issue #%d: %v", issueNumber, r)
+				slog.ErrorContext(ctx, "Error processing issue", "panic", r)
+				rec.fail(fmt.Errorf("panic: %v", r))
 			}
 		}()
 
-		// Initialize Session Service (InMemory)
-		sessionService := session.InMemoryService()
-
-		// Create Session
-		sess, err := sessionService.Create(ctx, &session.CreateRequest{
-			AppName: AppName,
-			UserID:  UserID,
-		})
-		if err != nil {
-			log.Printf("Error creating session for issue #%d: %v", issueNumber, err)
+		if audit.agent == nil {
+			decision, err := runRulesForIssue(ctx, audit.repo, issueNumber, ruleClassifier)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error processing issue", "error", err)
+				rec.fail(err)
+				return
+			}
+			slog.InfoContext(ctx, "Decision", "verdict", decision.Verdict, "report", decision.Report)
 			return
 		}
 
-		// Create runner
-		r, err := runner.New(runner.Config{
-			AppName:         AppName,
-			Agent:           rootAgent,
-			SessionService:  sessionService,
-			ArtifactService: artifact.InMemoryService(),
-			MemoryService:   memory.InMemoryService(),
-		})
+		report, err := runAgentForIssue(ctx, audit.agent, issueNumber)
 		if err != nil {
-			log.Fatalf("Failed to create runner: %v", err)
+			slog.ErrorContext(ctx, "Error running agent", "error", err)
+			rec.fail(err)
 		}
+		rec.setDecision(reportVerdict(report), report)
+	}()
 
-		// Construct Prompt
-		promptText := fmt.Sprintf("Audit Issue #%d.", issueNumber)
-		promptMessage := &genai.Content{
-			Role: "user",
-			Parts: []*genai.Part{
-				{Text: promptText},
-			},
-		}
+	res.duration = time.Since(startTime)
+	res.apiCalls = rec.finish().APICalls
+	slog.InfoContext(ctx, "Issue finished", "duration_seconds", res.duration.Seconds(), "api_calls", res.apiCalls)
+	return res
+}
 
-		eventStream := r.Run(ctx, UserID, sess.Session.ID(), promptMessage, agent.RunConfig{})
-		for event := range eventStream {
-			if event.Content != nil && len(event.Content.Parts) > 0 {
-				part := event.Content.Parts[0]
-				if part.Text != "" {
-					text := part.Text
-					cleanText := strings.ReplaceAll(text, "\n", " ")
-					if len(cleanText) > 150 {
-						cleanText = cleanText[:150]
-					}
-					log.Printf("#%d Decision: %s...", issueNumber, cleanText)
+// runAgentForIssue runs the agent on one issue in a fresh session and
+// returns its final report, the last text the model produced. A failed run
+// still returns the report so far.
+func runAgentForIssue(ctx context.Context, a agent.Agent, issueNumber int) (string, error) {
+	// Initialize Session Service (InMemory)
+	sessionService := session.InMemoryService()
+
+	// Create Session
+	sess, err := sessionService.Create(ctx, &session.CreateRequest{
+		AppName: AppName,
+		UserID:  UserID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating session: %w", err)
+	}
+
+	// Create runner
+	r, err := runner.New(runner.Config{
+		AppName:         AppName,
+		Agent:           a,
+		SessionService:  sessionService,
+		ArtifactService: artifact.InMemoryService(),
+		MemoryService:   memory.InMemoryService(),
+	})
+	if err != nil {
+		fatal("Failed to create runner", "error", err)
+	}
+
+	// Construct Prompt
+	promptText := fmt.Sprintf("Audit Issue #%d.", issueNumber)
+	promptMessage := &genai.Content{
+		Role: "user",
+		Parts: []*genai.Part{
+			{Text: promptText},
+		},
+	}
+
+	var report string
+	eventStream := r.Run(ctx, UserID, sess.Session.ID(), promptMessage, agent.RunConfig{})
+	for event, err := range eventStream {
+		if err != nil {
+			return report, err
+		}
+		if event.Content != nil && len(event.Content.Parts) > 0 {
+			part := event.Content.Parts[0]
+			if part.Text != "" {
+				text := part.Text
+				report = text
+				cleanText := strings.ReplaceAll(text, "\n", " ")
+				if len(cleanText) > 150 {
+					cleanText = cleanText[:150]
 				}
+				slog.InfoContext(ctx, "Decision", "report", cleanText)
 			}
 		}
-	}()
+	}
+	return report, nil
+}
 
-	res.duration = time.Since(startTime)
-	endAPICalls := GetAPICallCount()
-	res.apiCalls = endAPICalls - startAPICalls
-	log.Printf("Issue #%d finished in %.2fs with ~%d API calls.", issueNumber, res.duration.Seconds(), res.apiCalls)
+// processSinglePullRequest processes a single pull request with the pull
+// request rules, whichever decision engine is configured for issues.
+func processSinglePullRequest(ctx context.Context, audit *repoAudit, number int) processSingleResult {
+	if IssueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, IssueTimeout)
+		defer cancel()
+	}
+	ctx = withLogAttrs(ctx, "issue_number", number, "kind", "pull_request")
+	ctx, rec := startIssueAudit(ctx, audit.repo, "pull_request", number)
+
+	startTime := time.Now()
+	slog.InfoContext(ctx, "Processing pull request")
+
+	decision, err := runRulesForPullRequest(ctx, audit.repo, number)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error processing pull request", "error", err)
+		rec.fail(err)
+	} else {
+		slog.InfoContext(ctx, "Decision", "verdict", decision.Verdict, "report", decision.Report)
+	}
+
+	res := processSingleResult{
+		duration: time.Since(startTime),
+		apiCalls: rec.finish().APICalls,
+	}
+	slog.InfoContext(ctx, "Pull request finished", "duration_seconds", res.duration.Seconds(), "api_calls", res.apiCalls)
 	return res
 }
 
@@ -131,38 +225,62 @@ func loadPromptTemplate(filename string) (string, error) {
 	return string(data), nil
 }
 
-func setupTools() []tool.Tool {
-	t1, _ := functiontool.New(functiontool.Config{
-		Name:        "add_label_to_issue",
-		Description: "Adds a specific label to a GitHub issue.",
-	}, addLabelToIssue)
-
-	t2, _ := functiontool.New(functiontool.Config{
-		Name:        "remove_label_from_issue",
-		Description: "Remove a specific label from a GitHub issue.",
-	}, removeLabelFromIssue)
-
-	t3, _ := functiontool.New(functiontool.Config{
-		Name:        "add_stale_label_and_comment",
-		Description: "Marks the issue as stale with a comment and label.",
-	}, addStaleLabelAndComment)
-
-	t4, _ := functiontool.New(functiontool.Config{
-		Name:        "alert_maintainer_of_edit",
-		Description: "Post a comment alerting maintainers of a silent edit.",
-	}, alertMaintainerOfEdit)
-
-	t5, _ := functiontool.New(functiontool.Config{
-		Name:        "close_as_stale",
-		Description: "Close the issue as completed/stale.",
-	}, closeAsStale)
-
-	t6, _ := functiontool.New(functiontool.Config{
-		Name:        "get_issue_state",
-		Description: "Fetch and analyze the current state/history of the issue.",
-	}, getIssueState)
-
-	return []tool.Tool{t1, t2, t3, t4, t5, t6}
+// withToolContext adapts a context-aware tool implementation to the
+// functiontool handler signature. tool.Context carries the invocation's
+// context.Context, so cancellation reaches every GitHub call a tool makes;
+// the repository is attached for GitHub App auth, and every call is
+// recorded in the issue's audit record and the tool metrics.
+func withToolContext[TArgs, TResults any](repo *Repository, name string, fn func(context.Context, TArgs) (TResults, error)) func(tool.Context, TArgs) (TResults, error) {
+	return func(ctx tool.Context, args TArgs) (TResults, error) {
+		res, err := fn(withRepository(ctx, repo), args)
+		auditFrom(ctx).recordToolCall(name, args, res, err)
+		toolInvocations.Inc(name, toolOutcome(res, err))
+		return res, err
+	}
+}
+
+// issueToolset holds the implementations behind the agent's tools. The
+// eval swaps them for dataset-backed ones; names, descriptions and argument
+// types come from buildIssueTools either way.
+type issueToolset struct {
+	AddLabel      func(context.Context, LabelTargetArgs) (ToolResult, error)
+	RemoveLabel   func(context.Context, LabelTargetArgs) (ToolResult, error)
+	MarkStale     func(context.Context, IssueTargetArgs) (ToolResult, error)
+	AlertEdit     func(context.Context, IssueTargetArgs) (ToolResult, error)
+	CloseAsStale  func(context.Context, IssueTargetArgs) (ToolResult, error)
+	GetIssueState func(context.Context, IssueTargetArgs) (map[string]any, error)
+}
+
+func setupTools(repo *Repository) []tool.Tool {
+	return buildIssueTools(repo, issueToolset{
+		AddLabel:      repo.addLabelToIssue,
+		RemoveLabel:   repo.removeLabelFromIssue,
+		MarkStale:     repo.addStaleLabelAndComment,
+		AlertEdit:     repo.alertMaintainerOfEdit,
+		CloseAsStale:  repo.closeAsStale,
+		GetIssueState: repo.getIssueState,
+	})
+}
+
+// buildIssueTools declares the agent's tools on top of impl.
+func buildIssueTools(repo *Repository, impl issueToolset) []tool.Tool {
+	return []tool.Tool{
+		newTool(repo, "add_label_to_issue", "Adds a specific label to a GitHub issue.", impl.AddLabel),
+		newTool(repo, "remove_label_from_issue", "Remove a specific label from a GitHub issue.", impl.RemoveLabel),
+		newTool(repo, "add_stale_label_and_comment", "Marks the issue as stale with a comment and label.", impl.MarkStale),
+		newTool(repo, "alert_maintainer_of_edit", "Post a comment alerting maintainers of a silent edit.", impl.AlertEdit),
+		newTool(repo, "close_as_stale", "Close the issue as completed/stale.", impl.CloseAsStale),
+		newTool(repo, "get_issue_state", "Fetch and analyze the current state/history of the issue.", impl.GetIssueState),
+	}
+}
+
+// newTool declares one tool, recorded through withToolContext.
+func newTool[TArgs, TResults any](repo *Repository, name, description string, fn func(context.Context, TArgs) (TResults, error)) tool.Tool {
+	t, _ := functiontool.New(functiontool.Config{
+		Name:        name,
+		Description: description,
+	}, withToolContext(repo, name, fn))
+	return t
 }
 
 func formatPrompt(template string, values map[string]string) string {
@@ -173,115 +291,427 @@ func formatPrompt(template string, values map[string]string) string {
 	return result
 }
 
-func main() {
-	startTotalTime := time.Now()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	InitConfig()
-
-	var err error
-	PROMPT_TEMPLATE, err = loadPromptTemplate("PROMPT_INSTRUCTION.txt")
-	if err != nil {
-		log.Fatalf("Failed to load PROMPT_INSTRUCTION.txt: %v", err)
+// newRepoAudit prepares the agent for one repository. The rules engine
+// needs no agent.
+func newRepoAudit(repo *Repository, llm model.LLM) (*repoAudit, error) {
+	audit := &repoAudit{repo: repo}
+	if DecisionEngine != "llm" {
+		return audit, nil
 	}
 
-	log.Println("PROMPT_TEMPLATE loaded successfully.")
-	log.Printf("--- Starting Stale Bot for %s/%s ---", Owner, Repo)
-	log.Printf("Concurrency level set to %d", ConcurrencyLimit)
-
-	model, err := gemini.NewModel(ctx, geminiModel, &genai.ClientConfig{APIKey: os.Getenv("GOOGLE_API_KEY")})
+	var err error
+	audit.agent, err = newAuditorAgent(repo, llm, setupTools(repo))
 	if err != nil {
-		log.Fatalf("Failed to create model: %v", err)
+		return nil, err
 	}
+	return audit, nil
+}
 
+// newAuditorAgent builds the agent from PROMPT_TEMPLATE for repo with the
+// given tools.
+func newAuditorAgent(repo *Repository, llm model.LLM, tools []tool.Tool) (agent.Agent, error) {
 	instruction := formatPrompt(PROMPT_TEMPLATE, map[string]string{
-		"OWNER":                       Owner,
-		"REPO":                        Repo,
-		"STALE_LABEL_NAME":            STALE_LABEL_NAME,
-		"REQUEST_CLARIFICATION_LABEL": RequestClarificationLabel,
-		"stale_threshold_days":        fmt.Sprintf("%g", float64(STALE_HOURS_THRESHOLD)/24.0),
-		"close_threshold_days":        fmt.Sprintf("%g", float64(CLOSE_HOURS_AFTER_STALE_THRESHOLD)/24.0),
+		"OWNER":                       repo.Owner,
+		"REPO":                        repo.Name,
+		"STALE_LABEL_NAME":            repo.StaleLabelName,
+		"REQUEST_CLARIFICATION_LABEL": repo.RequestClarificationLabel,
+		"stale_threshold_days":        fmt.Sprintf("%g", repo.staleThresholdDays()),
+		"close_threshold_days":        fmt.Sprintf("%g", repo.closeThresholdDays()),
 	})
 
-	toolList := setupTools()
-	rootAgent, err = llmagent.New(llmagent.Config{
+	return llmagent.New(llmagent.Config{
 		Name:        "adk_repository_auditor_agent",
 		Description: "Audits open issues.",
-		Model:       model,
+		Model:       llm,
 		Instruction: instruction,
-		Tools:       toolList,
+		Tools:       tools,
 	})
+}
 
-	ResetAPICallCount()
-	filterDays := STALE_HOURS_THRESHOLD / 24.0
+// auditRepository searches one repository for candidate issues (and pull
+// requests, when enabled) and processes them on a pool of ConcurrencyLimit
+// workers.
+func auditRepository(ctx context.Context, audit *repoAudit) repoSummary {
+	ctx = withLogAttrs(withRepository(ctx, audit.repo), "repository", audit.repo.FullName())
+	startTime := time.Now()
+	startAPICalls := GetAPICallCount()
+	summary := repoSummary{repo: audit.repo.FullName()}
+	audit.repo.resetPrefetched()
+
+	slog.InfoContext(ctx, "Auditing repository")
 
-	allIssues, err := GetOldOpenIssueNumbers(Owner, Repo, &filterDays)
+	allIssues, err := FindCandidateIssues(ctx, audit.repo)
 	if err != nil {
-		log.Fatalf("Failed to fetch issue list: %v", err)
+		slog.ErrorContext(ctx, "Failed to fetch issue list", "error", err)
+		summary.err = err
+		return summary
 	}
 
-	totalCount := len(allIssues)
-	searchAPICalls := GetAPICallCount()
-	if totalCount == 0 {
-		log.Println("No issues matched the criteria. Run finished.")
-		return
+	var allPulls []int
+	if PullRequestsEnabled {
+		allPulls, err = GetOldOpenPullRequestNumbers(ctx, audit.repo)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to fetch pull request list", "error", err)
+			summary.err = err
+			return summary
+		}
 	}
 
-	log.Printf("Found %d issues to process. (Initial search used %d API calls).", totalCount, searchAPICalls)
+	summary.issuesFound = len(allIssues)
+	summary.pullsFound = len(allPulls)
+	summary.searchAPICalls = GetAPICallCount() - startAPICalls
+	if len(allIssues)+len(allPulls) == 0 {
+		slog.InfoContext(ctx, "No issues matched the criteria")
+		summary.duration = time.Since(startTime)
+		return summary
+	}
 
-	var totalProcessingTime time.Duration
-	var totalIssueAPICalls int
-	processedCount := 0
+	slog.InfoContext(ctx, "Found items to process",
+		"issues", len(allIssues), "pull_requests", len(allPulls), "search_api_calls", summary.searchAPICalls)
+
+	// With -issue only the selected issues are prefetched. A cassette run
+	// prefetches every candidate, so its batches are the same as in an
+	// unfiltered run and a filtered replay still matches the recording.
+	prefetchIssues := allIssues
+	if issueFilter != nil && !cassetteActive {
+		prefetchIssues = nil
+		for _, n := range allIssues {
+			if issueFilter[n] {
+				prefetchIssues = append(prefetchIssues, n)
+			}
+		}
+	}
 
-	for i := 0; i < totalCount; i += ConcurrencyLimit {
-		end := i + ConcurrencyLimit
-		if end > totalCount {
-			end = totalCount
+	// Issues are loaded in batched queries a little ahead of the workers, so
+	// get_issue_state does not need a round-trip per issue.
+	prefetched := 0
+	prefetch := func(end int) {
+		for prefetched < end {
+			n := audit.repo.prefetchIssues(ctx, prefetchIssues[prefetched:])
+			if n == 0 {
+				break
+			}
+			prefetched += n
 		}
-		chunk := allIssues[i:end]
-		currentChunkNum := (i / ConcurrencyLimit) + 1
-		log.Printf("--- Starting chunk %d: Processing issues %v ---", currentChunkNum, chunk)
-
-		var wg sync.WaitGroup
-		resultsChan := make(chan processSingleResult, len(chunk))
-
-		for _, issueNum := range chunk {
-			wg.Add(1)
-			go func(num int) {
-				defer wg.Done()
-				res := processSingleIssue(ctx, num)
-				resultsChan <- res
-			}(issueNum)
+	}
+
+	// positions maps each issue item back to prefetchIssues.
+	items := make([]workItem, 0, len(allIssues)+len(allPulls))
+	var positions []int
+	for i, n := range prefetchIssues {
+		if issueFilter != nil && !issueFilter[n] {
+			continue
+		}
+		positions = append(positions, i)
+		items = append(items, workItem{number: n, process: func(ctx context.Context, n int) processSingleResult {
+			return processSingleIssue(ctx, audit, n)
+		}})
+	}
+	for _, n := range allPulls {
+		if issueFilter != nil && !issueFilter[n] {
+			continue
+		}
+		items = append(items, workItem{number: n, process: func(ctx context.Context, n int) processSingleResult {
+			return processSinglePullRequest(ctx, audit, n)
+		}})
+	}
+	if issueFilter != nil {
+		slog.InfoContext(ctx, "Filtered items to process", "selected", len(items))
+	}
+
+	processQueue(ctx, &summary, items, func(i int) {
+		if i < len(positions) {
+			prefetch(min(positions[i]+ConcurrencyLimit, len(prefetchIssues)))
 		}
+	})
+
+	summary.duration = time.Since(startTime)
+	return summary
+}
 
+// workItem is one queued issue or pull request.
+type workItem struct {
+	number  int
+	process func(context.Context, int) processSingleResult
+}
+
+// processQueue runs every item on a pool of ConcurrencyLimit workers fed
+// from a job queue, so ConcurrencyLimit items are always in flight and a
+// slow one only holds up its own worker. beforeEnqueue, when set, runs
+// before item i is queued. Pacing is left to the shared GitHub and model
+// limiters. On cancellation no further items are queued, the items in flight
+// finish, and the rest are skipped.
+func processQueue(ctx context.Context, summary *repoSummary, items []workItem, beforeEnqueue func(i int)) {
+	jobs := make(chan workItem)
+	results := make(chan processSingleResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < ConcurrencyLimit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				results <- item.process(ctx, item.number)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i, item := range items {
+			if ctx.Err() != nil {
+				return
+			}
+			if beforeEnqueue != nil {
+				beforeEnqueue(i)
+			}
+			select {
+			case jobs <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
 		wg.Wait()
-		close(resultsChan)
+		close(results)
+	}()
+
+	for res := range results {
+		summary.processingTime += res.duration
+		summary.issueAPICalls += res.apiCalls
+		summary.processed++
+		slog.InfoContext(ctx, "Progress", "done", summary.processed, "total", len(items))
+	}
+
+	if remaining := len(items) - summary.processed; remaining > 0 {
+		slog.WarnContext(ctx, "Run cancelled, skipped the remaining items", "remaining", remaining)
+		summary.err = ctx.Err()
+	}
+}
+
+func main() {
+	configPath := flag.String("config", "", "path to the stale-bot YAML/JSON config file (default $STALE_BOT_CONFIG or "+DefaultConfigFile+")")
+	reportJSON := flag.String("report-json", "", "write the run report as JSON to this path")
+	reportMarkdown := flag.String("report-markdown", "", "write the run report as Markdown to this path")
+	recordCassette := flag.String("record-cassette", "", "record every GitHub request and response of the run to this cassette file")
+	replayCassette := flag.String("replay-cassette", "", "serve GitHub responses from this cassette instead of the network (implies dry run)")
+	issues := flag.String("issue", "", "only process these issue or pull request numbers (comma-separated)")
+	evalDataset := flag.String("eval", "", "score the agent on this labeled dataset (e.g. testdata/eval/dataset.yaml) and exit")
+	evalModels := flag.String("eval-models", "", "with -eval, the models to compare (comma-separated, default the configured model)")
+	evalJSON := flag.String("eval-json", "", "with -eval, write the scores as JSON to this path")
+	evalBaseline := flag.String("eval-baseline", "", "with -eval, an earlier -eval-json report to measure accuracy drops against")
+	flag.Parse()
+
+	if *recordCassette != "" && *replayCassette != "" {
+		fatal("-record-cassette and -replay-cassette are mutually exclusive")
+	}
+	if *issues != "" {
+		issueFilter = map[int]bool{}
+		for _, s := range strings.Split(*issues, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "#")))
+			if err != nil {
+				fatal("Invalid -issue value", "value", s)
+			}
+			issueFilter[n] = true
+		}
+	}
+
+	InitConfig(*configPath, *replayCassette != "" || *evalDataset != "")
+
+	// Cassettes run once: the clock is pinned to the recording time.
+	if *recordCassette != "" {
+		cassette, err := startRecording(*recordCassette)
+		if err != nil {
+			fatal("Failed to start recording", "error", err)
+		}
+		defer cassette.Close()
+		slog.Info("Recording GitHub traffic", "cassette", *recordCassette)
+		RunInterval = 0
+	}
+	if *replayCassette != "" {
+		if err := startReplay(*replayCassette); err != nil {
+			fatal("Failed to load cassette", "error", err)
+		}
+		DryRun = true
+		RunInterval = 0
+	}
+
+	// SIGINT/SIGTERM cancel in-flight work: retries and rate-limit sleeps
+	// abort, no new issues start, and tools that already started writing
+	// finish their writes.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	PROMPT_TEMPLATE, err = loadPromptTemplate("PROMPT_INSTRUCTION.txt")
+	if err != nil {
+		fatal("Failed to load PROMPT_INSTRUCTION.txt", "error", err)
+	}
+
+	slog.Debug("PROMPT_TEMPLATE loaded successfully.")
+
+	if *evalDataset != "" {
+		models := []string{geminiModel}
+		if *evalModels != "" {
+			models = strings.Split(*evalModels, ",")
+		}
+		if ModelScriptPath != "" {
+			models = []string{"scripted"}
+		}
+		os.Exit(runEval(ctx, *evalDataset, models, *evalJSON, *evalBaseline))
+	}
+	slog.Info("Starting Stale Bot", "repositories", len(Repositories), "concurrency", ConcurrencyLimit)
+	if DryRun {
+		slog.Info("DRY RUN enabled: mutating GitHub calls will be recorded, not sent.")
+	}
+
+	if AuditLogPath != "" {
+		auditSink, err = NewJSONLAuditSink(AuditLogPath)
+		if err != nil {
+			fatal("Failed to open audit log", "error", err)
+		}
+		defer auditSink.Close()
+	}
+
+	if MetricsListen != "" {
+		serveMetrics(ctx, MetricsListen)
+	}
+
+	if gitHubApp != nil {
+		slug, err := gitHubApp.Slug(ctx)
+		if err != nil {
+			fatal("Failed to authenticate as GitHub App", "app_id", gitHubApp.ID, "error", err)
+		}
+		// GraphQL reports bot authors by slug, without the "[bot]" suffix.
+		BOT_NAME = slug
+		slog.Info("Authenticated as GitHub App", "slug", slug, "bot_name", BOT_NAME)
+	}
+
+	var base model.LLM
+	if ModelScriptPath != "" {
+		slog.Info("Using scripted model, Gemini is not called", "script", ModelScriptPath)
+		base, err = LoadScriptedModel(ModelScriptPath)
+	} else {
+		base, err = gemini.NewModel(ctx, geminiModel, &genai.ClientConfig{APIKey: os.Getenv("GOOGLE_API_KEY")})
+	}
+	if err != nil {
+		fatal("Failed to create model", "error", err)
+	}
+	llm := configureEngine(base)
+
+	// With a run interval the bot keeps running as a daemon, serving metrics
+	// between runs; otherwise it runs once.
+	for {
+		runOnce(ctx, llm, *reportJSON, *reportMarkdown)
+		if RunInterval <= 0 || ctx.Err() != nil {
+			return
+		}
+		slog.Info("Waiting for the next run", "interval", RunInterval.String())
+		if err := sleepCtx(ctx, RunInterval); err != nil {
+			return
+		}
+	}
+}
 
-		for res := range resultsChan {
-			totalProcessingTime += res.duration
-			totalIssueAPICalls += res.apiCalls
+// configureEngine wraps the model with the rate limiter and metrics and
+// selects the rules engine's classifier. It returns the model to run with.
+func configureEngine(base model.LLM) model.LLM {
+	var llm model.LLM = meteredModel{limitedModel{base}}
+	if DecisionEngine == "rules" {
+		if RulesClassifier == "heuristic" {
+			ruleClassifier = heuristicClassifier{}
+		} else {
+			ruleClassifier = modelClassifier{llm: llm}
 		}
+	}
+	return llm
+}
 
-		processedCount += len(chunk)
-		log.Printf("--- Finished chunk %d. Progress: %d/%d ---", currentChunkNum, processedCount, totalCount)
+// runOnce audits every repository once, then logs the summary and writes
+// the run report and metrics.
+func runOnce(ctx context.Context, llm model.LLM, reportJSON, reportMarkdown string) {
+	startTotalTime := time.Now()
+
+	// The run deadline cancels in-flight work the same way a signal does.
+	if RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RunTimeout)
+		defer cancel()
+	}
 
-		if end < totalCount {
-			time.Sleep(time.Duration(SleepBetweenChunks * float64(time.Second)))
+	RunID = newRunID()
+	ctx = withLogAttrs(ctx, "run_id", RunID)
+	if auditSink != nil {
+		slog.InfoContext(ctx, "Appending decisions to the audit log", "path", AuditLogPath)
+	}
+	resetRunRecords()
+	resetPlan()
+	ResetAPICallCount()
+
+	var summaries []repoSummary
+	for _, repo := range Repositories {
+		if ctx.Err() != nil {
+			slog.WarnContext(ctx, "Run cancelled, skipping repository", "repository", repo.FullName(), "cause", context.Cause(ctx))
+			summaries = append(summaries, repoSummary{repo: repo.FullName(), err: ctx.Err()})
+			continue
+		}
+		audit, err := newRepoAudit(repo, llm)
+		if err != nil {
+			fatal("Failed to create agent", "repository", repo.FullName(), "error", err)
 		}
+		summaries = append(summaries, auditRepository(ctx, audit))
 	}
 
-	totalAPICallsForRun := searchAPICalls + totalIssueAPICalls
+	var totalProcessed int
+	var totalProcessingTime time.Duration
+	for _, s := range summaries {
+		totalProcessed += s.processed
+		totalProcessingTime += s.processingTime
+	}
 	avgTimePerIssue := 0.0
-	if totalCount > 0 {
-		avgTimePerIssue = totalProcessingTime.Seconds() / float64(totalCount)
+	if totalProcessed > 0 {
+		avgTimePerIssue = totalProcessingTime.Seconds() / float64(totalProcessed)
 	}
 
-	log.Println("--- Stale Agent Run Finished ---")
-	log.Printf("Successfully processed %d issues.", processedCount)
-	log.Printf("Total API calls made this run: %d", totalAPICallsForRun)
-	log.Printf("Average processing time per issue: %.2f seconds.", avgTimePerIssue)
+	for _, s := range summaries {
+		if s.err != nil {
+			slog.ErrorContext(ctx, "Repository failed", "repository", s.repo, "error", s.err)
+			continue
+		}
+		slog.InfoContext(ctx, "Repository finished",
+			"repository", s.repo,
+			"processed", s.processed,
+			"found", s.issuesFound+s.pullsFound,
+			"api_calls", s.searchAPICalls+s.issueAPICalls,
+			"duration_minutes", s.duration.Minutes(),
+		)
+	}
 
 	duration := time.Since(startTotalTime)
-	log.Printf("Full audit finished in %.2f minutes.", duration.Minutes())
+	slog.InfoContext(ctx, "Stale Agent Run Finished",
+		"processed", totalProcessed,
+		"repositories", len(summaries),
+		"api_calls", GetAPICallCount(),
+		"avg_seconds_per_item", avgTimePerIssue,
+		"duration_minutes", duration.Minutes(),
+	)
+
+	if DryRun {
+		printPlan(ctx)
+	}
+
+	report := buildRunReport(startTotalTime, summaries)
+	if err := writeRunReport(report, reportJSON, reportMarkdown); err != nil {
+		slog.WarnContext(ctx, "Failed to write run report", "error", err)
+	}
+
+	runsCompleted.Inc()
+	lastRunTimestamp.Set(float64(time.Now().Unix()))
+	if MetricsTextfile != "" {
+		if err := writeMetricsTextfile(MetricsTextfile); err != nil {
+			slog.WarnContext(ctx, "Failed to write metrics textfile", "error", err)
+		}
+	}
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"gopkg.in/yaml.v3"
+)
+
+// TestScenarios runs the bot's full flow (search, collaborators, GraphQL
+// analysis, decision, tool calls) against a FakeGitHub, one subtest per
+// testdata/scenarios file, then checks the fake's issues against the
+// scenario's expectations. Times in scenarios are relative to the start of the run.
+// Scenarios with a model section run against a ScriptedModel, so the agent
+// path and the model classifier are covered without calling Gemini.
+
+// Scenario is one testdata/scenarios/*.yaml file.
+type Scenario struct {
+	Name        string          `yaml:"name"`
+	Description string          `yaml:"description"`
+	Maintainers []string        `yaml:"maintainers"`
+	Issues      []ScenarioIssue `yaml:"issues"`
+
+	// Config is applied on top of the scenario defaults (rule engine,
+	// heuristic classifier, no audit log), in the config file format.
+	Config yaml.Node `yaml:"config"`
+
+	// Model, when set, replaces Gemini with a ScriptedModel and switches the
+	// default decision engine to the agent.
+	Model *ModelScript `yaml:"model"`
+}
+
+type ScenarioIssue struct {
+	Number          int     `yaml:"number"`
+	Author          string  `yaml:"author"`
+	CreatedHoursAgo float64 `yaml:"created_hours_ago"`
+
+	Labels []struct {
+		Name     string  `yaml:"name"`
+		By       string  `yaml:"by"`
+		HoursAgo float64 `yaml:"hours_ago"`
+	} `yaml:"labels"`
+
+	Comments []struct {
+		Author   string  `yaml:"author"`
+		Body     string  `yaml:"body"`
+		HoursAgo float64 `yaml:"hours_ago"`
+	} `yaml:"comments"`
+
+	Edits []struct {
+		Editor   string  `yaml:"editor"`
+		HoursAgo float64 `yaml:"hours_ago"`
+	} `yaml:"edits"`
+
+	Expect ScenarioExpectation `yaml:"expect"`
+}
+
+// ScenarioExpectation is the state an issue must be in after the run.
+// Empty fields are not checked; Labels, when set, must match exactly.
+type ScenarioExpectation struct {
+	Verdict         string   `yaml:"verdict"`
+	State           string   `yaml:"state"`
+	Labels          []string `yaml:"labels"`
+	NewComments     *int     `yaml:"new_comments"`
+	CommentContains string   `yaml:"comment_contains"`
+}
+
+func TestScenarios(t *testing.T) {
+	// Agent scenarios build their instruction from the real prompt.
+	var err error
+	PROMPT_TEMPLATE, err = loadPromptTemplate("PROMPT_INSTRUCTION.txt")
+	if err != nil {
+		t.Fatalf("loading PROMPT_INSTRUCTION.txt: %v", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.yaml"))
+	if err != nil || len(paths) == 0 {
+		t.Fatal("no scenarios in testdata/scenarios")
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			runScenarioFile(t, path)
+		})
+	}
+}
+
+func loadScenario(path string) (Scenario, error) {
+	var sc Scenario
+	f, err := os.Open(path)
+	if err != nil {
+		return sc, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return sc, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// runScenarioFile runs one scenario and reports the unmet expectations.
+func runScenarioFile(t *testing.T, path string) {
+	sc, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	ago := func(hours float64) time.Time {
+		return now.Add(-time.Duration(hours * float64(time.Hour)))
+	}
+
+	var issues []*FakeIssue
+	for _, si := range sc.Issues {
+		issue := &FakeIssue{Number: si.Number}
+		issue.Issue.Author = &GraphQLActor{Login: si.Author}
+		issue.Issue.CreatedAt = ago(si.CreatedHoursAgo)
+		for _, l := range si.Labels {
+			issue.Issue.Labels.Nodes = append(issue.Issue.Labels.Nodes, &GraphQLLabel{Name: l.Name})
+			if l.By != "" {
+				issue.Issue.TimelineItems.Nodes = append(issue.Issue.TimelineItems.Nodes, &GraphQLTimelineItem{
+					Typename:  "LabeledEvent",
+					CreatedAt: ago(l.HoursAgo),
+					Actor:     &GraphQLActor{Login: l.By},
+					Label:     &GraphQLLabel{Name: l.Name},
+				})
+			}
+		}
+		for _, c := range si.Comments {
+			issue.Issue.Comments.Nodes = append(issue.Issue.Comments.Nodes, &GraphQLComment{
+				Author:    &GraphQLActor{Login: c.Author},
+				Body:      c.Body,
+				CreatedAt: ago(c.HoursAgo),
+			})
+		}
+		for _, e := range si.Edits {
+			issue.Issue.UserContentEdits.Nodes = append(issue.Issue.UserContentEdits.Nodes, &GraphQLEdit{
+				Editor:   &GraphQLActor{Login: e.Editor},
+				EditedAt: ago(e.HoursAgo),
+			})
+		}
+		issues = append(issues, issue)
+	}
+
+	fake := NewFakeGitHub("scenario", "repo", sc.Maintainers, issues)
+	defer fake.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = fake.Owner, fake.Repo
+	cfg.GitHub.APIURL = fake.URL()
+	cfg.DecisionEngine = "rules"
+	cfg.RulesClassifier = "heuristic"
+	cfg.AuditLog = ""
+	if sc.Model != nil {
+		cfg.DecisionEngine = "llm"
+		cfg.RulesClassifier = "model"
+	}
+	if !sc.Config.IsZero() {
+		if err := sc.Config.Decode(&cfg); err != nil {
+			t.Fatalf("scenario config: %v", err)
+		}
+	}
+	problems := validateConfig(reflect.ValueOf(cfg), "")
+	problems = append(problems, validateRepositories(cfg.Repositories)...)
+	if len(problems) > 0 {
+		t.Fatal(&ConfigError{Problems: problems})
+	}
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "scenario-token"
+	gitHubApp = nil
+
+	var llm model.LLM
+	if sc.Model != nil {
+		llm = configureEngine(&ScriptedModel{Script: *sc.Model})
+	} else {
+		ruleClassifier = heuristicClassifier{}
+	}
+
+	t.Logf("%s: %d issues", sc.Name, len(issues))
+	runOnce(context.Background(), llm, "", "")
+
+	verdicts := map[int]string{}
+	recordsLock.Lock()
+	for _, rec := range runRecords {
+		verdicts[rec.Number] = rec.Verdict
+	}
+	recordsLock.Unlock()
+
+	for _, si := range sc.Issues {
+		got, ok := fake.Issue(si.Number)
+		if !ok {
+			t.Errorf("#%d: not served by the fake", si.Number)
+			continue
+		}
+		checkExpectation(t, si, got, verdicts[si.Number])
+	}
+}
+
+// checkExpectation compares an issue after the run with its expectation.
+func checkExpectation(t *testing.T, si ScenarioIssue, got FakeIssue, verdict string) {
+	t.Helper()
+	want := si.Expect
+	fail := func(format string, args ...any) {
+		t.Helper()
+		t.Errorf("#%d: %s", si.Number, fmt.Sprintf(format, args...))
+	}
+
+	if want.Verdict != "" && verdict != want.Verdict {
+		fail("verdict %q, want %q", verdict, want.Verdict)
+	}
+	if want.State != "" && got.State != want.State {
+		fail("state %q, want %q", got.State, want.State)
+	}
+	if want.Labels != nil {
+		have := append([]string{}, got.Issue.LabelNames()...)
+		expected := append([]string{}, want.Labels...)
+		sort.Strings(have)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(have, expected) {
+			fail("labels %q, want %q", have, expected)
+		}
+	}
+
+	added := got.Issue.Comments.Nodes[len(si.Comments):]
+	if want.NewComments != nil && len(added) != *want.NewComments {
+		fail("%d new comments, want %d", len(added), *want.NewComments)
+	}
+	if want.CommentContains != "" {
+		found := false
+		for _, c := range added {
+			if strings.Contains(c.Body, want.CommentContains) {
+				found = true
+			}
+		}
+		if !found {
+			fail("no new comment contains %q", want.CommentContains)
+		}
+	}
+}
+
+func TestIssueFilterPrefetch(t *testing.T) {
+	var issues []*FakeIssue
+	for n := 1; n <= 6; n++ {
+		issue := &FakeIssue{Number: n}
+		issue.Issue.Author = &GraphQLActor{Login: "alice"}
+		issue.Issue.CreatedAt = time.Now().Add(-1000 * time.Hour)
+		issues = append(issues, issue)
+	}
+	fake := NewFakeGitHub("filter", "repo", []string{"maintainer-a"}, issues)
+	defer fake.Close()
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = fake.Owner, fake.Repo
+	cfg.GitHub.APIURL = fake.URL()
+	cfg.DecisionEngine = "rules"
+	cfg.AuditLog = ""
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "filter-token"
+	gitHubApp = nil
+	ruleClassifier = heuristicClassifier{}
+
+	issueFilter = map[int]bool{4: true}
+	defer func() { issueFilter, cassetteActive = nil, false }()
+
+	tests := []struct {
+		name     string
+		cassette bool
+		wantLeft bool
+	}{
+		{"live run prefetches the selection only", false, false},
+		{"cassette run prefetches every candidate", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cassetteActive = tt.cassette
+			repo := Repositories[0]
+			audit, err := newRepoAudit(repo, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := auditRepository(context.Background(), audit)
+			if summary.err != nil || summary.processed != 1 {
+				t.Fatalf("processed %d items (error %v), want the selected one", summary.processed, summary.err)
+			}
+			// Processing takes the selected issue; anything else
+			// prefetched is left behind.
+			if left := len(repo.prefetched) > 0; left != tt.wantLeft {
+				t.Errorf("unselected issues prefetched = %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
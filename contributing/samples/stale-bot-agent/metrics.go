@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/adk/model"
+)
+
+// Metrics are kept in a small in-process registry and rendered in the
+// Prometheus text exposition format, either served on /metrics (daemon mode)
+// or written to a textfile at the end of every run, which node_exporter's
+// textfile collector and a Pushgateway both accept.
+
+var (
+	githubRequests = newCounterVec("stale_bot_github_requests_total",
+		"GitHub API requests by method, endpoint and response status.", "method", "endpoint", "status")
+	githubRetries = newCounterVec("stale_bot_github_retries_total",
+		"GitHub API requests retried after a failed attempt.", "method", "endpoint")
+	githubRateLimitRemaining = newGaugeVec("stale_bot_github_rate_limit_remaining",
+		"Remaining GitHub rate-limit budget by resource.", "resource")
+	llmCalls = newCounterVec("stale_bot_llm_calls_total",
+		"Model calls by model and outcome.", "model", "outcome")
+	llmTokens = newCounterVec("stale_bot_llm_tokens_total",
+		"Model tokens used by model and type (prompt or candidates).", "model", "type")
+	toolInvocations = newCounterVec("stale_bot_tool_invocations_total",
+		"Tool invocations by tool name and outcome.", "tool", "outcome")
+	itemDuration = newHistogram("stale_bot_item_duration_seconds",
+		"Time spent processing one issue or pull request.", "kind",
+		[]float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300})
+	verdicts = newCounterVec("stale_bot_verdicts_total",
+		"Processed items by kind and report verdict.", "kind", "verdict")
+	runsCompleted = newCounterVec("stale_bot_runs_total",
+		"Completed runs.")
+	lastRunTimestamp = newGaugeVec("stale_bot_last_run_timestamp_seconds",
+		"Unix time the last run finished.")
+)
+
+// ---------------- Registry ----------------
+
+var (
+	metricsRegistry []metric
+	metricsLock     sync.Mutex
+)
+
+// metric is one metric family that can render itself.
+type metric interface {
+	write(b *strings.Builder)
+}
+
+func register(m metric) {
+	metricsLock.Lock()
+	metricsRegistry = append(metricsRegistry, m)
+	metricsLock.Unlock()
+}
+
+// series holds the values of a family keyed by their joined label values.
+type series struct {
+	name, help, kind string
+	labels           []string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func (s *series) add(delta float64, labelValues ...string) {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("%s: got %d label values, want %d", s.name, len(labelValues), len(s.labels)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s.lock.Lock()
+	s.values[key] += delta
+	s.lock.Unlock()
+}
+
+func (s *series) set(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	s.lock.Lock()
+	s.values[key] = v
+	s.lock.Unlock()
+}
+
+func (s *series) get(labelValues ...string) float64 {
+	key := strings.Join(labelValues, "\x00")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.values[key]
+}
+
+func (s *series) write(b *strings.Builder) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+	for _, key := range sortedKeys(s.values) {
+		fmt.Fprintf(b, "%s%s %s\n", s.name, labelPairs(s.labels, strings.Split(key, "\x00"), ""), formatValue(s.values[key]))
+	}
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct{ s *series }
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{s: &series{name: name, help: help, kind: "counter", labels: labels, values: map[string]float64{}}}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) { c.s.add(1, labelValues...) }
+
+func (c *CounterVec) Add(v float64, labelValues ...string) { c.s.add(v, labelValues...) }
+
+// Value reads the current count, for in-process summaries such as -eval.
+func (c *CounterVec) Value(labelValues ...string) float64 { return c.s.get(labelValues...) }
+
+func (c *CounterVec) write(b *strings.Builder) { c.s.write(b) }
+
+// GaugeVec is a value per label set that can go up and down.
+type GaugeVec struct{ s *series }
+
+func newGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{s: &series{name: name, help: help, kind: "gauge", labels: labels, values: map[string]float64{}}}
+	register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) { g.s.set(v, labelValues...) }
+
+func (g *GaugeVec) write(b *strings.Builder) { g.s.write(b) }
+
+// Histogram counts observations into cumulative buckets, per value of one
+// label.
+type Histogram struct {
+	name, help, label string
+	buckets           []float64
+
+	lock   sync.Mutex
+	counts map[string][]uint64 // per label value, one count per bucket plus +Inf
+	sums   map[string]float64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(labelValue string, v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	counts, ok := h.counts[labelValue]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[labelValue] = counts
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	counts[i]++
+	h.sums[labelValue] += v
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, lv := range sortedKeys(h.counts) {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += h.counts[lv][i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, labelPairs([]string{h.label}, []string{lv}, formatValue(upper)), cumulative)
+		}
+		cumulative += h.counts[lv][len(h.buckets)]
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, labelPairs([]string{h.label}, []string{lv}, "+Inf"), cumulative)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, labelPairs([]string{h.label}, []string{lv}, ""), formatValue(h.sums[lv]))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, labelPairs([]string{h.label}, []string{lv}, ""), cumulative)
+	}
+}
+
+// ---------------- Exposition ----------------
+
+// renderMetrics renders every registered metric in the text format.
+func renderMetrics() string {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	var b strings.Builder
+	for _, m := range metricsRegistry {
+		m.write(&b)
+	}
+	return b.String()
+}
+
+// labelPairs renders {a="x",b="y"}, adding le when set.
+func labelPairs(names, values []string, le string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serveMetrics serves /metrics on addr until ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprint(w, renderMetrics())
+	})
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		slog.Info("Serving metrics", "addr", addr, "path", "/metrics")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Warn("Metrics server stopped", "error", err)
+		}
+	}()
+}
+
+// writeMetricsTextfile writes the metrics to path through a temporary file,
+// so collectors never read a partial file.
+func writeMetricsTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".stale-bot-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(renderMetrics()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ---------------- Instrumentation Helpers ----------------
+
+var (
+	repoPathPattern   = regexp.MustCompile(`^/repos/[^/]+/[^/]+`)
+	numberPathPattern = regexp.MustCompile(`/\d+(/|$)`)
+	labelPathPattern  = regexp.MustCompile(`/labels/[^/]+$`)
+)
+
+// metricsEndpoint reduces a request URL to a low-cardinality endpoint label:
+// the path below the API base with the repository, numbers and label names
+// replaced by placeholders.
+func metricsEndpoint(u *url.URL) string {
+	if u.String() == GitHubGraphQLURL {
+		return "graphql"
+	}
+	path := u.Path
+	if base, err := url.Parse(GitHubBaseURL); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(base.Path, "/"))
+	}
+	path = repoPathPattern.ReplaceAllString(path, "/repos/{owner}/{repo}")
+	path = numberPathPattern.ReplaceAllString(path, "/{number}$1")
+	path = labelPathPattern.ReplaceAllString(path, "/labels/{name}")
+	return path
+}
+
+// recordGitHubRequest counts one attempt of req; resp is nil when the
+// request failed without a response.
+func recordGitHubRequest(req *http.Request, resp *http.Response) {
+	status := "error"
+	if resp != nil {
+		status = fmt.Sprintf("%d", resp.StatusCode)
+	}
+	githubRequests.Inc(req.Method, metricsEndpoint(req.URL), status)
+}
+
+// toolOutcome classifies a tool result as "success" or "error".
+func toolOutcome(result any, err error) string {
+	if err != nil {
+		return "error"
+	}
+	if res, ok := result.(ToolResult); ok && res.Status != "success" {
+		return "error"
+	}
+	if m, ok := result.(map[string]any); ok {
+		if status, _ := m["status"].(string); status != "" && status != "success" {
+			return "error"
+		}
+	}
+	return "success"
+}
+
+// observeItem records the latency and verdict of a finished audit record.
+func observeItem(rec AuditRecord) {
+	itemDuration.Observe(rec.Kind, float64(rec.DurationMS)/1000)
+	verdicts.Inc(rec.Kind, reportItem(rec).Verdict)
+}
+
+// ---------------- Model Wrappers ----------------
+
+// limitedModel makes every call of the wrapped model wait for a token from
+// modelLimiter.
+type limitedModel struct {
+	model.LLM
+}
+
+func (m limitedModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if err := modelLimiter.Wait(ctx); err != nil {
+			yield(nil, err)
+			return
+		}
+		for resp, err := range m.LLM.GenerateContent(ctx, req, stream) {
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// meteredModel counts the calls and token usage of the wrapped model.
+type meteredModel struct {
+	model.LLM
+}
+
+func (m meteredModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		name := m.Name()
+		outcome := "success"
+		defer func() { llmCalls.Inc(name, outcome) }()
+
+		for resp, err := range m.LLM.GenerateContent(ctx, req, stream) {
+			if err != nil {
+				outcome = "error"
+			} else if resp != nil && resp.UsageMetadata != nil && !resp.Partial {
+				llmTokens.Add(float64(resp.UsageMetadata.PromptTokenCount), name, "prompt")
+				llmTokens.Add(float64(resp.UsageMetadata.CandidatesTokenCount), name, "candidates")
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// The issue query only fetches the most recent comments, edits and timeline
+// items (and the first 100 labels). completeIssueHistory walks the cursors
+// backwards until the events the decision depends on are known:
+//   - every label, so is_stale cannot miss the stale label;
+//   - the latest stale LabeledEvent, for days_since_stale_label;
+//   - every comment, since the replay places an edited comment at its
+//     lastEditedAt: an old comment edited yesterday is the latest activity
+//     even though it sits on an old page. This also finds the latest bot
+//     alert for maintainer_alert_needed;
+//   - every edit and timeline item newer than the latest human activity.
+//     Those connections are ordered by the time the replay uses, so older
+//     entries cannot change the outcome and walking stops there.
+
+// pageSize is used for every follow-up page, the maximum GitHub allows.
+const pageSize = 100
+
+// issueConnectionQuery builds a query that fetches one page of a single
+// connection of an issue.
+func issueConnectionQuery(selection string) string {
+	return `
+query($owner: String!, $name: String!, $number: Int!, $limit: Int!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    issue(number: $number) {
+      ` + selection + `
+    }
+  }
+}
+`
+}
+
+var issueConnectionQueries = map[string]string{
+	"labels":           issueConnectionQuery(`labels(first: $limit, after: $cursor) {` + labelFields + `}`),
+	"comments":         issueConnectionQuery(`comments(last: $limit, before: $cursor) {` + commentFields + `}`),
+	"userContentEdits": issueConnectionQuery(`userContentEdits(last: $limit, before: $cursor) {` + editFields + `}`),
+	"timelineItems":    issueConnectionQuery(`timelineItems(itemTypes: ` + timelineItemTypes + `, last: $limit, before: $cursor) {` + timelineFields + `}`),
+}
+
+// completeIssueHistory fetches further pages into issue until nothing
+// relevant can be missing, or until GraphQLMaxHistoryPages pages were spent,
+// in which case issue.HistoryTruncated is set.
+func (r *Repository) completeIssueHistory(ctx context.Context, itemNumber int, issue *GraphQLIssue) error {
+	pages := 0
+
+	for {
+		wanted := r.connectionsToPage(issue)
+		if len(wanted) == 0 {
+			return nil
+		}
+
+		if pages+len(wanted) > GraphQLMaxHistoryPages {
+			issue.HistoryTruncated = true
+			slog.WarnContext(ctx, "Issue history truncated",
+				"number", itemNumber, "extra_pages", pages, "incomplete", wanted)
+			return nil
+		}
+
+		for _, conn := range wanted {
+			if err := r.fetchIssueConnectionPage(ctx, itemNumber, conn, issue); err != nil {
+				return fmt.Errorf("paginating %s of issue #%d: %w", conn, itemNumber, err)
+			}
+			pages++
+		}
+	}
+}
+
+// connectionsToPage lists the connections that may still hide events
+// relevant to the decision.
+func (r *Repository) connectionsToPage(issue *GraphQLIssue) []string {
+	var wanted []string
+
+	// Labels first: whether the issue is stale decides what else to fetch.
+	if issue.Labels.PageInfo.HasNextPage {
+		return []string{"labels"}
+	}
+
+	history, labelEvents, _ := buildHistoryTimeline(issue, r.StaleLabelName)
+	horizon := history[len(history)-1].Time
+
+	// Comments are ordered by creation, not by the edit time the replay
+	// uses, so any older page may hold the latest activity.
+	if issue.Comments.PageInfo.HasPreviousPage {
+		wanted = append(wanted, "comments")
+	}
+
+	if issue.UserContentEdits.PageInfo.HasPreviousPage {
+		oldest := time.Time{}
+		for _, e := range issue.UserContentEdits.Nodes {
+			if e != nil && (oldest.IsZero() || e.EditedAt.Before(oldest)) {
+				oldest = e.EditedAt
+			}
+		}
+		if oldest.IsZero() || oldest.After(horizon) {
+			wanted = append(wanted, "userContentEdits")
+		}
+	}
+
+	if issue.TimelineItems.PageInfo.HasPreviousPage {
+		oldest := time.Time{}
+		for _, t := range issue.TimelineItems.Nodes {
+			if t != nil && (oldest.IsZero() || t.CreatedAt.Before(oldest)) {
+				oldest = t.CreatedAt
+			}
+		}
+		missingStaleEvent := containsString(issue.LabelNames(), r.StaleLabelName) && len(labelEvents) == 0
+		if missingStaleEvent || oldest.IsZero() || oldest.After(horizon) {
+			wanted = append(wanted, "timelineItems")
+		}
+	}
+
+	return wanted
+}
+
+// fetchIssueConnectionPage fetches the next page of one connection and
+// merges it into issue: labels are appended, older history is prepended.
+func (r *Repository) fetchIssueConnectionPage(ctx context.Context, itemNumber int, conn string, issue *GraphQLIssue) error {
+	var cursor string
+	switch conn {
+	case "labels":
+		cursor = issue.Labels.PageInfo.EndCursor
+	case "comments":
+		cursor = issue.Comments.PageInfo.StartCursor
+	case "userContentEdits":
+		cursor = issue.UserContentEdits.PageInfo.StartCursor
+	case "timelineItems":
+		cursor = issue.TimelineItems.PageInfo.StartCursor
+	}
+
+	variables := map[string]any{
+		"owner":  r.Owner,
+		"name":   r.Name,
+		"number": itemNumber,
+		"limit":  pageSize,
+		"cursor": cursor,
+	}
+
+	var data struct {
+		Repository *struct {
+			Issue *GraphQLIssue `json:"issue"`
+		} `json:"repository"`
+	}
+	if err := graphQLQuery(ctx, issueConnectionQueries[conn], variables, &data); err != nil {
+		return err
+	}
+	if data.Repository == nil || data.Repository.Issue == nil {
+		return fmt.Errorf("issue #%d disappeared while paginating", itemNumber)
+	}
+	page := data.Repository.Issue
+
+	switch conn {
+	case "labels":
+		issue.Labels.Nodes = append(issue.Labels.Nodes, page.Labels.Nodes...)
+		issue.Labels.PageInfo = page.Labels.PageInfo
+	case "comments":
+		issue.Comments.Nodes = append(page.Comments.Nodes, issue.Comments.Nodes...)
+		issue.Comments.PageInfo = page.Comments.PageInfo
+	case "userContentEdits":
+		issue.UserContentEdits.Nodes = append(page.UserContentEdits.Nodes, issue.UserContentEdits.Nodes...)
+		issue.UserContentEdits.PageInfo = page.UserContentEdits.PageInfo
+	case "timelineItems":
+		issue.TimelineItems.Nodes = append(page.TimelineItems.Nodes, issue.TimelineItems.Nodes...)
+		issue.TimelineItems.PageInfo = page.TimelineItems.PageInfo
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PlannedAction is a mutating GitHub request issued by one of the agent tools.
+// In dry-run mode it is recorded in the run plan instead of being sent.
+type PlannedAction struct {
+	Repository  string `json:"repository"`
+	IssueNumber int    `json:"issue_number"`
+	Tool        string `json:"tool"`
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Payload     any    `json:"payload,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// ---------------- Run Plan ----------------
+
+var (
+	runPlan  []PlannedAction
+	planLock sync.Mutex
+)
+
+func recordPlannedAction(ctx context.Context, action PlannedAction) {
+	planLock.Lock()
+	runPlan = append(runPlan, action)
+	planLock.Unlock()
+
+	slog.InfoContext(ctx, "[DRY RUN] Planned action", "tool", action.Tool, "method", action.Method, "url", action.URL)
+}
+
+// resetPlan starts an empty plan for a new run.
+func resetPlan() {
+	planLock.Lock()
+	runPlan = nil
+	planLock.Unlock()
+}
+
+// GetPlan returns a copy of the actions recorded so far in this run.
+func GetPlan() []PlannedAction {
+	planLock.Lock()
+	defer planLock.Unlock()
+	return append([]PlannedAction(nil), runPlan...)
+}
+
+// ---------------- Mutation Dispatch ----------------
+
+// sendMutation executes a mutating GitHub request, or records it in the run
+// plan when DryRun is enabled.
+func sendMutation(ctx context.Context, action PlannedAction) error {
+	if DryRun {
+		recordPlannedAction(ctx, action)
+		return nil
+	}
+
+	var err error
+	switch {
+	case action.URL == GitHubGraphQLURL:
+		// GraphQL mutations carry {"query", "variables"} as their payload.
+		payload, _ := action.Payload.(map[string]any)
+		query, _ := payload["query"].(string)
+		variables, _ := payload["variables"].(map[string]any)
+		var data map[string]any
+		err = graphQLQuery(ctx, query, variables, &data)
+	case action.Method == "POST" && action.Comment != "":
+		_, err = PostCommentRequest(ctx, action.URL, action.Comment)
+	case action.Method == "POST":
+		_, err = PostRequest(ctx, action.URL, action.Payload)
+	case action.Method == "PATCH":
+		_, err = PatchRequest(ctx, action.URL, action.Payload)
+	case action.Method == "DELETE":
+		_, err = DeleteRequest(ctx, action.URL)
+	default:
+		err = fmt.Errorf("unsupported mutation method %q", action.Method)
+	}
+	return err
+}
+
+// successResult is the ToolResult returned after a mutation went through
+// sendMutation, making it explicit to the model when nothing was sent.
+func successResult() ToolResult {
+	if DryRun {
+		return ToolResult{
+			Status:  "success",
+			Message: "dry run: request recorded in the run plan, not sent to GitHub",
+		}
+	}
+	return ToolResult{
+		Status: "success",
+	}
+}
+
+// mutationGrace bounds how long a tool's writes may outlive cancellation.
+var mutationGrace = 30 * time.Second
+
+// mutationContext returns the context a tool uses for its GitHub writes. No
+// write starts once ctx is cancelled, but a tool that has started gets up
+// to mutationGrace after the cancellation to finish all of its writes, so
+// shutdown rarely leaves an issue half-updated (e.g. a stale comment
+// without the stale label) and never waits on a rate-limit sleep or retries.
+// While ctx stays live, writes are not bounded here.
+func mutationContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	writeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(mutationGrace, cancel)
+	})
+	return writeCtx, func() {
+		stop()
+		cancel()
+	}, nil
+}
+
+// ---------------- Plan Output ----------------
+
+func printPlan(ctx context.Context) {
+	plan := GetPlan()
+
+	slog.InfoContext(ctx, "Dry run plan", "planned_actions", len(plan))
+	for i, action := range plan {
+		attrs := []any{
+			"step", i + 1,
+			"repository", action.Repository,
+			"issue_number", action.IssueNumber,
+			"tool", action.Tool,
+			"method", action.Method,
+			"url", action.URL,
+		}
+		if action.Payload != nil {
+			payload, err := json.Marshal(action.Payload)
+			if err != nil {
+				payload = []byte(fmt.Sprintf("%v", action.Payload))
+			}
+			attrs = append(attrs, "payload", string(payload))
+		}
+		if action.Comment != "" {
+			attrs = append(attrs, "comment", action.Comment)
+		}
+		slog.InfoContext(ctx, "Planned action", attrs...)
+	}
+}
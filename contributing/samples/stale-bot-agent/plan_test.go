@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowLabelServer accepts label writes after delay.
+func slowLabelServer(t *testing.T, delay time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request context only notices a dropped client once the
+		// body has been read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "plan", "repo"
+	cfg.GitHub.APIURL = srv.URL
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	GitHubToken = "plan-token"
+	gitHubApp = nil
+	DryRun = false
+	return srv
+}
+
+func withMutationGrace(t *testing.T, d time.Duration) {
+	saved := mutationGrace
+	mutationGrace = d
+	t.Cleanup(func() { mutationGrace = saved })
+}
+
+func TestMutationOutlivesGraceWhileLive(t *testing.T) {
+	withMutationGrace(t, 50*time.Millisecond)
+	slowLabelServer(t, 300*time.Millisecond)
+
+	res, err := Repositories[0].addLabelToIssue(context.Background(), LabelTargetArgs{IssueNumber: 1, LabelName: "stale"})
+	if err != nil || res.Status != "success" {
+		t.Fatalf("write longer than the grace period with a live parent = %+v, %v; want success", res, err)
+	}
+}
+
+func TestMutationBoundedAfterCancel(t *testing.T) {
+	withMutationGrace(t, 50*time.Millisecond)
+	slowLabelServer(t, 5*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	res, _ := Repositories[0].addLabelToIssue(ctx, LabelTargetArgs{IssueNumber: 1, LabelName: "stale"})
+	if res.Status == "success" {
+		t.Fatal("write succeeded, want it cut off after the grace period")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("write ended %s after start, want about the grace period", elapsed)
+	}
+}
+
+func TestMutationNotStartedAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := mutationContext(ctx); err == nil {
+		t.Fatal("mutationContext on a cancelled context succeeded")
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Pull requests follow their own decision tree. Whether a PR is waiting on
+// its author is structural (reviews, review requests, commits, CI), so no
+// comment needs classifying and the tree runs as Go rules with either
+// decision engine:
+//   - "changes requested, no new commits" is the PR equivalent of a
+//     maintainer question, and failing CI on an untouched head counts too,
+//     with its own stale comment;
+//   - a PR with pending review requests waits on reviewers, never the author;
+//   - stale PRs are converted to drafts and later closed, each on its own
+//     threshold.
+
+// ---------------- Query Types ----------------
+
+type GraphQLReview struct {
+	Author      *GraphQLActor `json:"author"`
+	State       string        `json:"state"`
+	SubmittedAt *time.Time    `json:"submittedAt"`
+}
+
+type GraphQLReviewRequest struct {
+	RequestedReviewer *struct {
+		Login string `json:"login"` // User, Bot or Mannequin
+		Name  string `json:"name"`  // Team
+	} `json:"requestedReviewer"`
+}
+
+type GraphQLPullRequestCommit struct {
+	Commit struct {
+		// pushedDate is when the commit reached GitHub; GitHub leaves it
+		// null for some commits, so committedDate is the fallback.
+		PushedDate        *time.Time `json:"pushedDate"`
+		CommittedDate     time.Time  `json:"committedDate"`
+		StatusCheckRollup *struct {
+			State string `json:"state"`
+		} `json:"statusCheckRollup"`
+	} `json:"commit"`
+}
+
+// GraphQLPullRequest is the repository.pullRequest object returned by the
+// pull request query.
+type GraphQLPullRequest struct {
+	ID             string        `json:"id"`
+	Author         *GraphQLActor `json:"author"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	IsDraft        bool          `json:"isDraft"`
+	ReviewDecision string        `json:"reviewDecision"`
+
+	Labels struct {
+		Nodes []*GraphQLLabel `json:"nodes"`
+	} `json:"labels"`
+
+	Reviews struct {
+		PageInfo GraphQLPageInfo  `json:"pageInfo"`
+		Nodes    []*GraphQLReview `json:"nodes"`
+	} `json:"reviews"`
+
+	ReviewRequests struct {
+		PageInfo GraphQLPageInfo         `json:"pageInfo"`
+		Nodes    []*GraphQLReviewRequest `json:"nodes"`
+	} `json:"reviewRequests"`
+
+	Commits struct {
+		Nodes []*GraphQLPullRequestCommit `json:"nodes"`
+	} `json:"commits"`
+
+	Comments struct {
+		Nodes []*GraphQLComment `json:"nodes"`
+	} `json:"comments"`
+
+	TimelineItems struct {
+		Nodes []*GraphQLTimelineItem `json:"nodes"`
+	} `json:"timelineItems"`
+}
+
+const pullRequestQuery = `
+query($owner: String!, $name: String!, $number: Int!, $commentLimit: Int!, $timelineLimit: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      id
+      author { login }
+      createdAt
+      isDraft
+      reviewDecision
+      labels(first: 100) { nodes { name } }
+
+      reviews(last: 50) {
+        pageInfo { hasPreviousPage }
+        nodes {
+          author { login }
+          state
+          submittedAt
+        }
+      }
+
+      reviewRequests(first: 50) {
+        pageInfo { hasNextPage }
+        nodes {
+          requestedReviewer {
+            ... on User { login }
+            ... on Bot { login }
+            ... on Mannequin { login }
+            ... on Team { name }
+          }
+        }
+      }
+
+      commits(last: 1) {
+        nodes {
+          commit {
+            pushedDate
+            committedDate
+            statusCheckRollup { state }
+          }
+        }
+      }
+
+      comments(last: $commentLimit) {
+        nodes {
+          author { login }
+          body
+          createdAt
+        }
+      }
+
+      timelineItems(itemTypes: [LABELED_EVENT, HEAD_REF_FORCE_PUSHED_EVENT], last: $timelineLimit) {
+        nodes {
+          __typename
+          ... on LabeledEvent {
+            createdAt
+            actor { login }
+            label { name }
+          }
+          ... on HeadRefForcePushedEvent {
+            createdAt
+            actor { login }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// FetchPullRequest fetches the state of one pull request.
+func (r *Repository) FetchPullRequest(ctx context.Context, number int) (*GraphQLPullRequest, error) {
+	variables := map[string]any{
+		"owner":         r.Owner,
+		"name":          r.Name,
+		"number":        number,
+		"commentLimit":  GraphQLCommentLimit,
+		"timelineLimit": GraphQLTimelineLimit,
+	}
+
+	var data struct {
+		Repository *struct {
+			PullRequest *GraphQLPullRequest `json:"pullRequest"`
+		} `json:"repository"`
+	}
+
+	err := graphQLQuery(ctx, pullRequestQuery, variables, &data)
+	if err := graphQLDataError(ctx, err, "number", number); err != nil {
+		return nil, err
+	}
+
+	if data.Repository == nil {
+		return nil, notFoundError("repository %s", r.FullName())
+	}
+	if data.Repository.PullRequest == nil {
+		return nil, notFoundError("pull request #%d", number)
+	}
+	return data.Repository.PullRequest, nil
+}
+
+// ---------------- Analysis ----------------
+
+// PullRequestAnalysis is the derived state of a pull request that drives its
+// decision tree.
+type PullRequestAnalysis struct {
+	Repository *Repository `json:"-"`
+	Number     int         `json:"number"`
+	NodeID     string      `json:"node_id"`
+	Author     string      `json:"author"`
+	Labels     []string    `json:"labels"`
+	IsDraft    bool        `json:"is_draft"`
+	IsStale    bool        `json:"is_stale"`
+
+	// Latest review requesting changes that is still in effect, if any.
+	ChangesRequestedAt *time.Time `json:"changes_requested_at"`
+	// Latest push (head commit or force push) and latest author activity,
+	// which includes pushes and the author's own comments.
+	LastPushAt           time.Time  `json:"last_push_at"`
+	LastAuthorActivityAt time.Time  `json:"last_author_activity_at"`
+	StaleLabeledAt       *time.Time `json:"stale_labeled_at"`
+
+	PendingReviewers []string `json:"pending_reviewers"`
+	// Set when reviews or review requests do not fit the query window, so
+	// a change request or pending reviewer may be missing.
+	ReviewsTruncated bool `json:"reviews_truncated"`
+	// statusCheckRollup of the head commit: SUCCESS, FAILURE, ERROR,
+	// PENDING, EXPECTED, or "" without checks.
+	CIState string `json:"ci_state"`
+}
+
+// analyzePullRequest fetches a pull request and derives its analysis.
+func (r *Repository) analyzePullRequest(ctx context.Context, number int) (*PullRequestAnalysis, error) {
+	pr, err := r.FetchPullRequest(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+
+	a := &PullRequestAnalysis{
+		Repository: r,
+		Number:     number,
+		NodeID:     pr.ID,
+		Author:     LoginOf(pr.Author),
+		Labels:     labelNames(pr.Labels.Nodes),
+		IsDraft:    pr.IsDraft,
+		LastPushAt: pr.CreatedAt,
+	}
+	a.IsStale = containsString(a.Labels, r.StaleLabelName)
+
+	for _, c := range pr.Commits.Nodes {
+		if c == nil {
+			continue
+		}
+		// The commit date is the author's, and a rebased or long-unpushed
+		// commit keeps an old one, so the push time is preferred.
+		pushedAt := c.Commit.CommittedDate
+		if c.Commit.PushedDate != nil {
+			pushedAt = *c.Commit.PushedDate
+		}
+		if pushedAt.After(a.LastPushAt) {
+			a.LastPushAt = pushedAt
+		}
+		if c.Commit.StatusCheckRollup != nil {
+			a.CIState = c.Commit.StatusCheckRollup.State
+		}
+	}
+
+	for _, t := range pr.TimelineItems.Nodes {
+		if t == nil {
+			continue
+		}
+		switch t.Typename {
+		case "HeadRefForcePushedEvent":
+			if t.CreatedAt.After(a.LastPushAt) {
+				a.LastPushAt = t.CreatedAt
+			}
+		case "LabeledEvent":
+			if t.Label != nil && t.Label.Name == r.StaleLabelName &&
+				(a.StaleLabeledAt == nil || t.CreatedAt.After(*a.StaleLabeledAt)) {
+				labeledAt := t.CreatedAt
+				a.StaleLabeledAt = &labeledAt
+			}
+		}
+	}
+
+	// The labeling may be older than the timeline window; the bot's own
+	// stale comment, posted with the label, stands in for it.
+	if a.IsStale && a.StaleLabeledAt == nil {
+		a.StaleLabeledAt = lastStaleComment(pr.Comments.Nodes)
+		if a.StaleLabeledAt != nil {
+			slog.InfoContext(ctx, "Stale label event not found, using the stale comment", "commented_at", *a.StaleLabeledAt)
+		}
+	}
+
+	a.LastAuthorActivityAt = a.LastPushAt
+	for _, c := range pr.Comments.Nodes {
+		if c != nil && LoginOf(c.Author) == a.Author && c.CreatedAt.After(a.LastAuthorActivityAt) {
+			a.LastAuthorActivityAt = c.CreatedAt
+		}
+	}
+
+	// Only the latest review of each reviewer counts: an approval after a
+	// change request lifts it.
+	latest := map[string]*GraphQLReview{}
+	for _, rv := range pr.Reviews.Nodes {
+		if rv == nil || rv.SubmittedAt == nil || rv.State == "COMMENTED" || rv.State == "PENDING" {
+			continue
+		}
+		login := LoginOf(rv.Author)
+		if login == a.Author {
+			continue
+		}
+		if prev, ok := latest[login]; !ok || rv.SubmittedAt.After(*prev.SubmittedAt) {
+			latest[login] = rv
+		}
+	}
+	for _, rv := range latest {
+		if rv.State == "CHANGES_REQUESTED" && (a.ChangesRequestedAt == nil || rv.SubmittedAt.After(*a.ChangesRequestedAt)) {
+			a.ChangesRequestedAt = rv.SubmittedAt
+		}
+	}
+
+	a.ReviewsTruncated = pr.Reviews.PageInfo.HasPreviousPage || pr.ReviewRequests.PageInfo.HasNextPage
+	if a.ReviewsTruncated {
+		slog.WarnContext(ctx, "Reviews exceed the query window, skipping pull request",
+			"reviews", len(pr.Reviews.Nodes), "review_requests", len(pr.ReviewRequests.Nodes))
+	}
+
+	for _, rr := range pr.ReviewRequests.Nodes {
+		if rr == nil || rr.RequestedReviewer == nil {
+			continue
+		}
+		name := rr.RequestedReviewer.Login
+		if name == "" {
+			name = rr.RequestedReviewer.Name
+		}
+		a.PendingReviewers = append(a.PendingReviewers, name)
+	}
+
+	return a, nil
+}
+
+// lastStaleComment returns when the bot last posted a pull request stale
+// comment, matched on the template text before its first placeholder.
+func lastStaleComment(comments []*GraphQLComment) *time.Time {
+	var prefixes []string
+	for _, tmpl := range []string{PRStaleCommentTemplate, PRStaleCICommentTemplate} {
+		prefix, _, _ := strings.Cut(tmpl, "{")
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	var last *time.Time
+	for _, c := range comments {
+		if c == nil || LoginOf(c.Author) != BOT_NAME || (last != nil && !c.CreatedAt.After(*last)) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.TrimSpace(c.Body), prefix) {
+				commentedAt := c.CreatedAt
+				last = &commentedAt
+				break
+			}
+		}
+	}
+	return last
+}
+
+// ---------------- Decision Tree ----------------
+
+// evaluatePullRequest applies the pull request decision tree.
+func evaluatePullRequest(a *PullRequestAnalysis) Decision {
+	n := a.Number
+	repo := a.Repository
+	now := clock().UTC()
+	days := func(t time.Time) float64 { return now.Sub(t).Hours() / 24.0 }
+
+	// Without every review and review request the PR may still be waiting
+	// on a reviewer, so it is left alone.
+	if a.ReviewsTruncated {
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Pull Request #%d: ACTIVE. Too many reviews to judge safely. Skipped, no action.", n),
+		}
+	}
+
+	// STEP 1: Already stale
+	if a.IsStale {
+		if a.StaleLabeledAt != nil && a.LastAuthorActivityAt.After(*a.StaleLabeledAt) {
+			return Decision{
+				Verdict: VerdictActive,
+				Actions: []RuleAction{{Tool: "remove_label_from_issue", Label: repo.StaleLabelName}},
+				Report:  fmt.Sprintf("Analysis for Pull Request #%d: ACTIVE. Author activity since marked stale. Removed stale label.", n),
+			}
+		}
+
+		// Counting from an unknown time would never reach a threshold.
+		if a.StaleLabeledAt == nil {
+			return Decision{
+				Verdict: VerdictStale,
+				Report:  fmt.Sprintf("Analysis for Pull Request #%d: STALE. No record of when it was marked stale. Skipped, no action.", n),
+			}
+		}
+
+		staleDays := days(*a.StaleLabeledAt)
+		if staleDays*24 > PRCloseHoursAfterStale {
+			return Decision{
+				Verdict: VerdictStale,
+				Actions: []RuleAction{{Tool: "close_pull_request"}},
+				Report:  fmt.Sprintf("Analysis for Pull Request #%d: STALE. Close threshold met. Closing.", n),
+			}
+		}
+		if !a.IsDraft && PRDraftHoursAfterStale > 0 && staleDays*24 > PRDraftHoursAfterStale {
+			return Decision{
+				Verdict: VerdictStale,
+				Actions: []RuleAction{{Tool: "convert_pull_request_to_draft"}},
+				Report:  fmt.Sprintf("Analysis for Pull Request #%d: STALE. Draft threshold met. Converting to draft.", n),
+			}
+		}
+		return Decision{
+			Verdict: VerdictStale,
+			Report:  fmt.Sprintf("Analysis for Pull Request #%d: STALE. Waiting for close threshold. No action.", n),
+		}
+	}
+
+	// STEP 2: Waiting on reviewers
+	if len(a.PendingReviewers) > 0 {
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Pull Request #%d: ACTIVE. Waiting on review from %v. No action.", n, a.PendingReviewers),
+		}
+	}
+
+	// STEP 3: Waiting on the author
+	var waitingSince *time.Time
+	reason, staleReason := "", ""
+	switch {
+	case a.ChangesRequestedAt != nil && !a.LastAuthorActivityAt.After(*a.ChangesRequestedAt):
+		waitingSince, reason, staleReason = a.ChangesRequestedAt, "Changes requested", PRStaleChangesRequested
+	case a.CIState == "FAILURE" || a.CIState == "ERROR":
+		waitingSince, reason, staleReason = &a.LastAuthorActivityAt, "CI failing", PRStaleCIFailing
+	default:
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Pull Request #%d: ACTIVE. Not waiting on the author. No action.", n),
+		}
+	}
+
+	idle := days(*waitingSince)
+	if idle*24 <= PRStaleHoursThreshold {
+		return Decision{
+			Verdict: VerdictPending,
+			Report:  fmt.Sprintf("Analysis for Pull Request #%d: PENDING. %s, but threshold not met yet. No action.", n, reason),
+		}
+	}
+	return Decision{
+		Verdict: VerdictStale,
+		Actions: []RuleAction{{Tool: "mark_pull_request_stale", Reason: staleReason}},
+		Report:  fmt.Sprintf("Analysis for Pull Request #%d: STALE. %s %.1f days ago with no new commits. Marking stale.", n, reason, idle),
+	}
+}
+
+// applyPullRequestDecision runs the actions of a pull request decision.
+func applyPullRequestDecision(ctx context.Context, a *PullRequestAnalysis, decision Decision) error {
+	repo := a.Repository
+	args := PullRequestTargetArgs{PullRequestNumber: a.Number, NodeID: a.NodeID}
+
+	for _, action := range decision.Actions {
+		var res ToolResult
+		var err error
+
+		switch action.Tool {
+		case "remove_label_from_issue":
+			res, err = repo.removeLabelFromIssue(ctx, LabelTargetArgs{IssueNumber: a.Number, LabelName: action.Label})
+		case "mark_pull_request_stale":
+			staleArgs := args
+			staleArgs.Reason = action.Reason
+			res, err = repo.markPullRequestStale(ctx, staleArgs)
+		case "convert_pull_request_to_draft":
+			res, err = repo.convertPullRequestToDraft(ctx, args)
+		case "close_pull_request":
+			res, err = repo.closePullRequest(ctx, args)
+		default:
+			return fmt.Errorf("unknown pull request action %q", action.Tool)
+		}
+
+		auditFrom(ctx).recordToolCall(action.Tool, action, res, err)
+		toolInvocations.Inc(action.Tool, toolOutcome(res, err))
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", action.Tool, err)
+		}
+		if res.Status != "success" {
+			return fmt.Errorf("%s failed: %s", action.Tool, res.Message)
+		}
+		slog.InfoContext(ctx, "Applied action", "tool", action.Tool, "status", res.Status, "message", res.Message)
+	}
+	return nil
+}
+
+// runRulesForPullRequest evaluates and applies the decision tree for one
+// pull request.
+func runRulesForPullRequest(ctx context.Context, repo *Repository, number int) (Decision, error) {
+	analysis, err := repo.analyzePullRequest(ctx, number)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	auditFrom(ctx).setState(analysis)
+
+	decision := evaluatePullRequest(analysis)
+	auditFrom(ctx).setDecision(decision.Verdict, decision.Report)
+	if err := applyPullRequestDecision(ctx, analysis, decision); err != nil {
+		return decision, err
+	}
+	return decision, nil
+}
+
+// ---------------- Actions ----------------
+
+// PullRequestTargetArgs identifies a pull request. The GraphQL node ID is
+// needed for mutations without a REST equivalent, like converting to draft.
+type PullRequestTargetArgs struct {
+	PullRequestNumber int    `json:"pull_request_number"`
+	NodeID            string `json:"node_id"`
+	// Why the pull request is being marked stale; picks the stale comment.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Reasons a pull request is marked stale.
+const (
+	PRStaleChangesRequested = "changes_requested"
+	PRStaleCIFailing        = "ci_failing"
+)
+
+// commentOnPullRequest posts a comment on a pull request's conversation.
+func (r *Repository) commentOnPullRequest(ctx context.Context, number int, tool, comment string) error {
+	commentURL := fmt.Sprintf(
+		"%s/repos/%s/%s/issues/%d/comments",
+		GitHubBaseURL, r.Owner, r.Name, number,
+	)
+
+	return sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: number,
+		Tool:        tool,
+		Method:      "POST",
+		URL:         commentURL,
+		Payload:     map[string]string{"body": comment},
+		Comment:     comment,
+	})
+}
+
+func (r *Repository) markPullRequestStale(ctx context.Context, args PullRequestTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
+	template := PRStaleCommentTemplate
+	if args.Reason == PRStaleCIFailing {
+		template = PRStaleCICommentTemplate
+	}
+	comment := formatPrompt(template, map[string]string{
+		"stale_days": formatDays(PRStaleHoursThreshold),
+		"close_days": formatDays(PRCloseHoursAfterStale),
+	})
+
+	// 1. Post comment
+	if err := r.commentOnPullRequest(ctx, args.PullRequestNumber, "mark_pull_request_stale", comment); err != nil {
+		return toolFailure("posting stale comment", err)
+	}
+
+	// 2. Add label
+	labelURL := fmt.Sprintf(
+		"%s/repos/%s/%s/issues/%d/labels",
+		GitHubBaseURL, r.Owner, r.Name, args.PullRequestNumber,
+	)
+
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.PullRequestNumber,
+		Tool:        "mark_pull_request_stale",
+		Method:      "POST",
+		URL:         labelURL,
+		Payload:     []string{r.StaleLabelName},
+	})
+	if err != nil {
+		return toolFailure("adding stale label", err)
+	}
+
+	return successResult(), nil
+}
+
+func (r *Repository) convertPullRequestToDraft(ctx context.Context, args PullRequestTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
+	// 1. Post comment
+	comment := formatPrompt(PRDraftCommentTemplate, map[string]string{
+		"close_days": formatDays(PRCloseHoursAfterStale),
+	})
+	if err := r.commentOnPullRequest(ctx, args.PullRequestNumber, "convert_pull_request_to_draft", comment); err != nil {
+		return toolFailure("posting draft comment", err)
+	}
+
+	// 2. Convert (GraphQL only, there is no REST endpoint)
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.PullRequestNumber,
+		Tool:        "convert_pull_request_to_draft",
+		Method:      "POST",
+		URL:         GitHubGraphQLURL,
+		Payload: map[string]any{
+			"query":     `mutation($id: ID!) { convertPullRequestToDraft(input: {pullRequestId: $id}) { pullRequest { isDraft } } }`,
+			"variables": map[string]any{"id": args.NodeID},
+		},
+	})
+	if err != nil {
+		return toolFailure("converting pull request to draft", err)
+	}
+
+	return successResult(), nil
+}
+
+func (r *Repository) closePullRequest(ctx context.Context, args PullRequestTargetArgs) (ToolResult, error) {
+	ctx, cancel, err := mutationContext(ctx)
+	if err != nil {
+		return toolFailure("starting GitHub write", err)
+	}
+	defer cancel()
+
+	// 1. Post comment
+	comment := formatPrompt(PRCloseCommentTemplate, map[string]string{
+		"close_days": formatDays(PRCloseHoursAfterStale),
+	})
+	if err := r.commentOnPullRequest(ctx, args.PullRequestNumber, "close_pull_request", comment); err != nil {
+		return toolFailure("posting close comment", err)
+	}
+
+	// 2. Close pull request
+	prURL := fmt.Sprintf(
+		"%s/repos/%s/%s/pulls/%d",
+		GitHubBaseURL, r.Owner, r.Name, args.PullRequestNumber,
+	)
+
+	err = sendMutation(ctx, PlannedAction{
+		Repository:  r.FullName(),
+		IssueNumber: args.PullRequestNumber,
+		Tool:        "close_pull_request",
+		Method:      "PATCH",
+		URL:         prURL,
+		Payload:     map[string]string{"state": "closed"},
+	})
+	if err != nil {
+		return toolFailure("closing pull request", err)
+	}
+
+	return successResult(), nil
+}
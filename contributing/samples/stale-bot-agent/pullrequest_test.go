@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var prTestNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// setupPullRequestTest applies the default config in dry-run mode and pins
+// the clock to prTestNow.
+func setupPullRequestTest(t *testing.T) *Repository {
+	t.Helper()
+	cfg := defaultFileConfig()
+	cfg.Owner, cfg.Repo = "pr", "repo"
+	cfg.DryRun = true
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	resetPlan()
+
+	saved := clock
+	clock = func() time.Time { return prTestNow }
+	t.Cleanup(func() { clock = saved })
+	return Repositories[0]
+}
+
+// analyzeTestPullRequest serves pr, the JSON of a repository.pullRequest
+// object, from a fake GraphQL endpoint and analyzes it.
+func analyzeTestPullRequest(t *testing.T, pr string) *PullRequestAnalysis {
+	t.Helper()
+	repo := setupPullRequestTest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"repository":{"pullRequest":` + pr + `}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	GitHubGraphQLURL = srv.URL
+	GitHubToken = "pr-token"
+	gitHubApp = nil
+
+	a, err := repo.analyzePullRequest(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func daysAgo(d float64) *time.Time {
+	t := prTestNow.Add(-time.Duration(d * 24 * float64(time.Hour)))
+	return &t
+}
+
+func TestEvaluatePullRequest(t *testing.T) {
+	repo := setupPullRequestTest(t)
+
+	tests := []struct {
+		name        string
+		analysis    PullRequestAnalysis
+		wantVerdict string
+		wantTool    string
+		wantReason  string
+	}{
+		{
+			name: "changes requested past threshold",
+			analysis: PullRequestAnalysis{
+				ChangesRequestedAt:   daysAgo(20),
+				LastPushAt:           *daysAgo(30),
+				LastAuthorActivityAt: *daysAgo(30),
+			},
+			wantVerdict: VerdictStale,
+			wantTool:    "mark_pull_request_stale",
+			wantReason:  PRStaleChangesRequested,
+		},
+		{
+			name: "failing CI past threshold",
+			analysis: PullRequestAnalysis{
+				LastPushAt:           *daysAgo(20),
+				LastAuthorActivityAt: *daysAgo(20),
+				CIState:              "FAILURE",
+			},
+			wantVerdict: VerdictStale,
+			wantTool:    "mark_pull_request_stale",
+			wantReason:  PRStaleCIFailing,
+		},
+		{
+			name: "stale without a known stale time",
+			analysis: PullRequestAnalysis{
+				IsStale:              true,
+				LastPushAt:           *daysAgo(60),
+				LastAuthorActivityAt: *daysAgo(60),
+			},
+			wantVerdict: VerdictStale,
+		},
+		{
+			name: "stale past close threshold",
+			analysis: PullRequestAnalysis{
+				IsStale:              true,
+				StaleLabeledAt:       daysAgo(20),
+				LastPushAt:           *daysAgo(60),
+				LastAuthorActivityAt: *daysAgo(60),
+			},
+			wantVerdict: VerdictStale,
+			wantTool:    "close_pull_request",
+		},
+		{
+			name: "failing CI within threshold",
+			analysis: PullRequestAnalysis{
+				LastPushAt:           *daysAgo(2),
+				LastAuthorActivityAt: *daysAgo(2),
+				CIState:              "ERROR",
+			},
+			wantVerdict: VerdictPending,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.analysis
+			a.Repository, a.Number = repo, 1
+			d := evaluatePullRequest(&a)
+			if d.Verdict != tt.wantVerdict {
+				t.Errorf("verdict = %s, want %s (report %q)", d.Verdict, tt.wantVerdict, d.Report)
+			}
+			var tool, reason string
+			if len(d.Actions) > 0 {
+				tool, reason = d.Actions[0].Tool, d.Actions[0].Reason
+			}
+			if tool != tt.wantTool || reason != tt.wantReason {
+				t.Errorf("action = %q (reason %q), want %q (reason %q)", tool, reason, tt.wantTool, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMarkPullRequestStaleComment(t *testing.T) {
+	repo := setupPullRequestTest(t)
+
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{PRStaleChangesRequested, PRStaleCommentTemplate},
+		{PRStaleCIFailing, PRStaleCICommentTemplate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			resetPlan()
+			args := PullRequestTargetArgs{PullRequestNumber: 1, Reason: tt.reason}
+			if res, err := repo.markPullRequestStale(context.Background(), args); err != nil || res.Status != "success" {
+				t.Fatalf("markPullRequestStale = %+v, %v", res, err)
+			}
+			want := formatPrompt(tt.want, map[string]string{
+				"stale_days": formatDays(PRStaleHoursThreshold),
+				"close_days": formatDays(PRCloseHoursAfterStale),
+			})
+			if plan := GetPlan(); len(plan) == 0 || plan[0].Comment != want {
+				t.Errorf("stale comment for %s = %+v, want %q", tt.reason, plan, want)
+			}
+		})
+	}
+}
+
+func TestAnalyzePullRequestLastPush(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit string
+		want   string
+	}{
+		{
+			name:   "push time over commit time",
+			commit: `{"pushedDate": "2026-02-27T00:00:00Z", "committedDate": "2026-01-01T00:00:00Z"}`,
+			want:   "2026-02-27T00:00:00Z",
+		},
+		{
+			name:   "commit time without push time",
+			commit: `{"pushedDate": null, "committedDate": "2026-01-01T00:00:00Z"}`,
+			want:   "2026-01-01T00:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := analyzeTestPullRequest(t, `{
+				"id": "PR_1", "author": {"login": "alice"}, "createdAt": "2025-12-01T00:00:00Z",
+				"commits": {"nodes": [{"commit": `+tt.commit+`}]}
+			}`)
+			if got := a.LastPushAt.Format(time.RFC3339); got != tt.want {
+				t.Errorf("LastPushAt = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzePullRequestStaleTime(t *testing.T) {
+	const base = `"id": "PR_1", "author": {"login": "alice"}, "createdAt": "2025-12-01T00:00:00Z",
+		"labels": {"nodes": [{"name": "stale"}]}`
+
+	tests := []struct {
+		name string
+		pr   string
+		want string
+	}{
+		{
+			name: "labeled event",
+			pr: `"timelineItems": {"nodes": [
+				{"__typename": "LabeledEvent", "createdAt": "2026-02-01T00:00:00Z", "label": {"name": "stale"}}
+			]}`,
+			want: "2026-02-01T00:00:00Z",
+		},
+		{
+			name: "bot stale comment without labeled event",
+			pr: `"comments": {"nodes": [
+				{"author": {"login": "adk-bot"}, "body": "This pull request has been automatically marked as stale because its checks have been failing for 14 days and no new commits have been pushed since.", "createdAt": "2026-02-02T00:00:00Z"},
+				{"author": {"login": "adk-bot"}, "body": "Converting this pull request to a draft", "createdAt": "2026-02-10T00:00:00Z"},
+				{"author": {"login": "mallory"}, "body": "This pull request has been automatically marked as stale because", "createdAt": "2026-02-12T00:00:00Z"}
+			]}`,
+			want: "2026-02-02T00:00:00Z",
+		},
+		{
+			name: "neither",
+			pr:   `"comments": {"nodes": []}`,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := analyzeTestPullRequest(t, "{"+base+", "+tt.pr+"}")
+			got := ""
+			if a.StaleLabeledAt != nil {
+				got = a.StaleLabeledAt.Format(time.RFC3339)
+			}
+			if got != tt.want {
+				t.Errorf("StaleLabeledAt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitHub tracks separate budgets per resource ("core" for REST, "graphql",
+// "search"). The state below mirrors the X-RateLimit-* headers of the most
+// recent response for each resource.
+
+const (
+	// Never sleep longer than this for a single rate-limit wait; beyond it
+	// the request fails instead of silently stalling the run.
+	maxRateLimitWait = 15 * time.Minute
+	// Upper bound of the random jitter added to every retry delay.
+	maxRetryJitter = time.Second
+)
+
+// RateLimit is the last known budget for one GitHub rate-limit resource.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+var (
+	rateLimits    = map[string]RateLimit{}
+	rateLimitLock sync.Mutex
+)
+
+// GetRateLimit returns the last known budget for a resource ("core",
+// "graphql" or "search"). ok is false until a response reported it.
+func GetRateLimit(resource string) (RateLimit, bool) {
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	rl, ok := rateLimits[resource]
+	return rl, ok
+}
+
+// rateLimitResource guesses which budget a request draws from, so the wait
+// can happen before the request is sent.
+func rateLimitResource(req *http.Request) string {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/graphql"):
+		return "graphql"
+	case strings.Contains(req.URL.Path, "/search/"):
+		return "search"
+	default:
+		return "core"
+	}
+}
+
+// updateRateLimit records the X-RateLimit-* headers of a response.
+func updateRateLimit(req *http.Request, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	resetUnix, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+
+	resource := resp.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = rateLimitResource(req)
+	}
+
+	githubRateLimitRemaining.Set(float64(remaining), resource)
+
+	rateLimitLock.Lock()
+	rateLimits[resource] = RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(resetUnix, 0),
+	}
+	rateLimitLock.Unlock()
+}
+
+// rateLimitWait returns how long to wait before sending a request so it does
+// not hit an exhausted budget.
+func rateLimitWait(req *http.Request) time.Duration {
+	rl, ok := GetRateLimit(rateLimitResource(req))
+	if !ok || rl.Remaining > 0 {
+		return 0
+	}
+	wait := time.Until(rl.Reset) + time.Second
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// isRateLimited reports whether a response was rejected by a primary or
+// secondary rate limit. GitHub answers those with 403 or 429, so the body is
+// read to tell them apart from permission errors and put back afterwards.
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	if resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	msg := strings.ToLower(string(body))
+	return strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "rate limit exceeded")
+}
+
+// retryDelay picks the wait before the next attempt: Retry-After when GitHub
+// sent one, the budget reset when it is exhausted, otherwise the exponential
+// backoff. Jitter is added so concurrent workers do not retry in lockstep.
+func retryDelay(resp *http.Response, backoff time.Duration) time.Duration {
+	delay := backoff
+
+	if resp != nil {
+		if v := resp.Header.Get("Retry-After"); v != "" {
+			if secs, err := strconv.Atoi(v); err == nil {
+				delay = time.Duration(secs) * time.Second
+			} else if t, err := http.ParseTime(v); err == nil {
+				delay = time.Until(t)
+			}
+		} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if resetUnix, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				delay = time.Until(time.Unix(resetUnix, 0)) + time.Second
+			}
+		}
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	return delay + time.Duration(rand.Int63n(int64(maxRetryJitter)))
+}
+
+// ---------------- Token Bucket ----------------
+
+// Shared limiters for every GitHub request and every model call, whichever
+// worker makes them. nil limiters do not limit.
+var (
+	githubLimiter *TokenBucket
+	modelLimiter  *TokenBucket
+)
+
+// TokenBucket allows rate events per second with bursts of up to burst.
+// Waiters reserve a token up front, so they are served in arrival order.
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done. A cancelled wait
+// gives its token back.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.lock.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleepCtx(ctx, wait); err != nil {
+		b.lock.Lock()
+		b.tokens++
+		b.lock.Unlock()
+		return err
+	}
+	return nil
+}
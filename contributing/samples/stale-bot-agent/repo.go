@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Repository is one audit target with its effective labels and thresholds.
+// Everything that used to be process-wide state (maintainers cache, tools,
+// issue search) is scoped to a Repository so one run can audit many repos.
+type Repository struct {
+	Owner string
+	Name  string
+
+	// Labels
+	StaleLabelName            string
+	RequestClarificationLabel string
+
+	// Thresholds (hours)
+	StaleHoursThreshold           float64
+	CloseHoursAfterStaleThreshold float64
+
+	maintainersLock  sync.Mutex
+	maintainersCache []string
+
+	// Issues fetched by prefetchIssues, consumed by FetchGraphQLData.
+	prefetchLock sync.Mutex
+	prefetched   map[int]*GraphQLIssue
+}
+
+// FullName returns the repository as "owner/name".
+func (r *Repository) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+func (r *Repository) staleThresholdDays() float64 {
+	return r.StaleHoursThreshold / 24.0
+}
+
+func (r *Repository) closeThresholdDays() float64 {
+	return r.CloseHoursAfterStaleThreshold / 24.0
+}
+
+type repositoryKey struct{}
+
+// withRepository records the repository that GitHub calls made with ctx act
+// on, so GitHub App auth can pick the matching installation.
+func withRepository(ctx context.Context, repo *Repository) context.Context {
+	return context.WithValue(ctx, repositoryKey{}, repo)
+}
+
+// requestRepository returns "owner/name" for a request: the repository in
+// its context, else the one in a /repos/{owner}/{name}/... path, else "".
+func requestRepository(req *http.Request) string {
+	if repo, ok := req.Context().Value(repositoryKey{}).(*Repository); ok {
+		return repo.FullName()
+	}
+	_, rest, found := strings.Cut(req.URL.Path, "/repos/")
+	if !found {
+		return ""
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
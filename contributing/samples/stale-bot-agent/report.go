@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The run report summarizes a whole run from the audit records collected
+// while it ran, as JSON for tooling and as Markdown for humans. In GitHub
+// Actions the Markdown is appended to the job summary.
+
+// Report verdicts. ACTIVE, PENDING and STALE come from the decision; CLOSED
+// overrides them when the item was closed (WOULD_CLOSE in a dry run, where
+// nothing is sent), ERROR when processing failed.
+const (
+	ReportActive     = "ACTIVE"
+	ReportPending    = "PENDING"
+	ReportStale      = "STALE"
+	ReportClosed     = "CLOSED"
+	ReportWouldClose = "WOULD_CLOSE"
+	ReportError      = "ERROR"
+)
+
+// reportVerdicts lists the verdicts a run can report.
+func reportVerdicts(dryRun bool) []string {
+	closed := ReportClosed
+	if dryRun {
+		closed = ReportWouldClose
+	}
+	return []string{ReportActive, ReportPending, ReportStale, closed, ReportError}
+}
+
+var (
+	runRecords  []AuditRecord
+	recordsLock sync.Mutex
+)
+
+// resetRunRecords starts collecting for a new run.
+func resetRunRecords() {
+	recordsLock.Lock()
+	runRecords = nil
+	recordsLock.Unlock()
+}
+
+func collectRecord(rec AuditRecord) {
+	recordsLock.Lock()
+	runRecords = append(runRecords, rec)
+	recordsLock.Unlock()
+}
+
+// RunReport is the machine-readable summary of one run.
+type RunReport struct {
+	RunID           string       `json:"run_id"`
+	StartedAt       time.Time    `json:"started_at"`
+	FinishedAt      time.Time    `json:"finished_at"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	DryRun          bool         `json:"dry_run"`
+	Engine          string       `json:"engine"`
+	Totals          ReportTotals `json:"totals"`
+	Repositories    []RepoReport `json:"repositories"`
+	Items           []ReportItem `json:"items"`
+}
+
+type ReportTotals struct {
+	Processed         int            `json:"processed"`
+	Verdicts          map[string]int `json:"verdicts"`
+	APICalls          int            `json:"api_calls"`
+	AvgSecondsPerItem float64        `json:"avg_seconds_per_item"`
+}
+
+type RepoReport struct {
+	Repository        string  `json:"repository"`
+	IssuesFound       int     `json:"issues_found"`
+	PullRequestsFound int     `json:"pull_requests_found"`
+	Processed         int     `json:"processed"`
+	APICalls          int     `json:"api_calls"`
+	DurationSeconds   float64 `json:"duration_seconds"`
+	Error             string  `json:"error,omitempty"`
+}
+
+type ReportItem struct {
+	Repository string   `json:"repository"`
+	Number     int      `json:"number"`
+	Kind       string   `json:"kind"`
+	Verdict    string   `json:"verdict"`
+	Actions    []string `json:"actions"`
+	Error      string   `json:"error,omitempty"`
+	Report     string   `json:"report,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+	APICalls   int      `json:"api_calls"`
+}
+
+// reportItem condenses an audit record into its report line.
+func reportItem(rec AuditRecord) ReportItem {
+	item := ReportItem{
+		Repository: rec.Repository,
+		Number:     rec.Number,
+		Kind:       rec.Kind,
+		Verdict:    rec.Verdict,
+		Actions:    []string{},
+		Error:      rec.Error,
+		Report:     rec.Report,
+		DurationMS: rec.DurationMS,
+		APICalls:   rec.APICalls,
+	}
+
+	closed := false
+	for _, call := range rec.ToolCalls {
+		if call.Tool == "get_issue_state" {
+			continue
+		}
+
+		action := call.Tool
+		if ra, ok := call.Args.(RuleAction); ok && ra.Label != "" {
+			action += fmt.Sprintf("(%s)", ra.Label)
+		} else if la, ok := call.Args.(LabelTargetArgs); ok {
+			action += fmt.Sprintf("(%s)", la.LabelName)
+		}
+
+		ok := call.Error == ""
+		if res, isResult := call.Result.(ToolResult); isResult && res.Status != "success" {
+			ok = false
+		}
+		if !ok {
+			action += " [failed]"
+		} else if call.Tool == "close_as_stale" || call.Tool == "close_pull_request" {
+			closed = true
+		}
+		item.Actions = append(item.Actions, action)
+	}
+
+	switch {
+	case item.Error != "":
+		item.Verdict = ReportError
+	case closed && DryRun:
+		item.Verdict = ReportWouldClose
+	case closed:
+		item.Verdict = ReportClosed
+	case item.Verdict == "":
+		item.Verdict = ReportError
+		item.Error = "no verdict in the final report"
+	}
+	return item
+}
+
+// buildRunReport assembles the report from the repository summaries and the
+// audit records of this run.
+func buildRunReport(started time.Time, summaries []repoSummary) RunReport {
+	recordsLock.Lock()
+	records := append([]AuditRecord(nil), runRecords...)
+	recordsLock.Unlock()
+
+	report := RunReport{
+		RunID:           RunID,
+		StartedAt:       started.UTC(),
+		FinishedAt:      time.Now().UTC(),
+		DurationSeconds: time.Since(started).Seconds(),
+		DryRun:          DryRun,
+		Engine:          DecisionEngine,
+		Totals: ReportTotals{
+			Verdicts: map[string]int{},
+			APICalls: GetAPICallCount(),
+		},
+		Repositories: []RepoReport{},
+		Items:        []ReportItem{},
+	}
+	for _, v := range reportVerdicts(DryRun) {
+		report.Totals.Verdicts[v] = 0
+	}
+
+	var processingTime time.Duration
+	for _, s := range summaries {
+		rr := RepoReport{
+			Repository:        s.repo,
+			IssuesFound:       s.issuesFound,
+			PullRequestsFound: s.pullsFound,
+			Processed:         s.processed,
+			APICalls:          s.searchAPICalls + s.issueAPICalls,
+			DurationSeconds:   s.duration.Seconds(),
+		}
+		if s.err != nil {
+			rr.Error = s.err.Error()
+		}
+		report.Repositories = append(report.Repositories, rr)
+		processingTime += s.processingTime
+	}
+
+	for _, rec := range records {
+		item := reportItem(rec)
+		report.Items = append(report.Items, item)
+		report.Totals.Verdicts[item.Verdict]++
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		return a.Number < b.Number
+	})
+
+	report.Totals.Processed = len(report.Items)
+	if report.Totals.Processed > 0 {
+		report.Totals.AvgSecondsPerItem = processingTime.Seconds() / float64(report.Totals.Processed)
+	}
+	return report
+}
+
+// ---------------- Output ----------------
+
+// Markdown renders the report for humans, one table per repository.
+func (r RunReport) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## Stale bot run `%s`\n\n", r.RunID)
+	if r.DryRun {
+		b.WriteString("> **Dry run**: actions were planned, not sent to GitHub.\n\n")
+	}
+	fmt.Fprintf(&b, "Processed **%d** items with the `%s` engine in %.1f minutes using %d API calls (%.1fs per item).\n\n",
+		r.Totals.Processed, r.Engine, r.DurationSeconds/60, r.Totals.APICalls, r.Totals.AvgSecondsPerItem)
+
+	b.WriteString("| Verdict | Count |\n|---|---|\n")
+	for _, v := range reportVerdicts(r.DryRun) {
+		fmt.Fprintf(&b, "| %s | %d |\n", v, r.Totals.Verdicts[v])
+	}
+
+	for _, repo := range r.Repositories {
+		fmt.Fprintf(&b, "\n### %s\n\n", repo.Repository)
+		if repo.Error != "" {
+			fmt.Fprintf(&b, "**Failed:** %s\n\n", markdownCell(repo.Error))
+		}
+		fmt.Fprintf(&b, "Processed %d of %d items (%d issues, %d pull requests), %d API calls, %.1f minutes.\n\n",
+			repo.Processed, repo.IssuesFound+repo.PullRequestsFound, repo.IssuesFound, repo.PullRequestsFound,
+			repo.APICalls, repo.DurationSeconds/60)
+
+		rows := 0
+		for _, item := range r.Items {
+			if item.Repository != repo.Repository {
+				continue
+			}
+			if rows == 0 {
+				b.WriteString("| # | Kind | Verdict | Actions | Error |\n|---|---|---|---|---|\n")
+			}
+			rows++
+			fmt.Fprintf(&b, "| #%d | %s | %s | %s | %s |\n",
+				item.Number, item.Kind, item.Verdict,
+				markdownCell(strings.Join(item.Actions, ", ")), markdownCell(item.Error))
+		}
+	}
+	return b.String()
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// writeRunReport writes the JSON and Markdown reports to the given paths
+// (each optional) and appends the Markdown to $GITHUB_STEP_SUMMARY when set.
+func writeRunReport(report RunReport, jsonPath, markdownPath string) error {
+	if jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(jsonPath, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("writing JSON report: %w", err)
+		}
+	}
+
+	markdown := report.Markdown()
+	if markdownPath != "" {
+		if err := os.WriteFile(markdownPath, []byte(markdown), 0o644); err != nil {
+			return fmt.Errorf("writing Markdown report: %w", err)
+		}
+	}
+
+	if summaryPath := os.Getenv("GITHUB_STEP_SUMMARY"); summaryPath != "" {
+		f, err := os.OpenFile(summaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening step summary: %w", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(markdown + "\n"); err != nil {
+			return fmt.Errorf("writing step summary: %w", err)
+		}
+	}
+	return nil
+}
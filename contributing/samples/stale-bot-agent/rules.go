@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// The rule engine is a Go-native implementation of the decision tree in
+// PROMPT_INSTRUCTION.txt. Steps 1 and 2 are evaluated mechanically from the
+// IssueAnalysis; only step 3 consults a CommentClassifier to judge the last
+// maintainer comment.
+
+const (
+	VerdictActive  = "ACTIVE"
+	VerdictPending = "PENDING"
+	VerdictStale   = "STALE"
+)
+
+// CommentClassification is the judgement made on the last maintainer comment.
+type CommentClassification struct {
+	IsQuestion         bool `json:"is_question"`
+	InternalDiscussion bool `json:"internal_discussion"`
+}
+
+// CommentClassifier decides whether the last maintainer comment on an issue
+// asks the author for something, or is a discussion between maintainers.
+type CommentClassifier interface {
+	Classify(ctx context.Context, analysis *IssueAnalysis) (CommentClassification, error)
+}
+
+// RuleAction is a tool call chosen by the rule engine.
+type RuleAction struct {
+	Tool   string
+	Label  string
+	Reason string
+}
+
+// Decision is the outcome of evaluating the decision tree for one issue.
+type Decision struct {
+	Verdict string
+	Actions []RuleAction
+	Report  string
+}
+
+// ---------------- Decision Tree ----------------
+
+func evaluateIssue(ctx context.Context, analysis *IssueAnalysis, classifier CommentClassifier) (Decision, error) {
+	n := analysis.IssueNumber
+	repo := analysis.Repository
+	role := analysis.State.LastActionRole
+	byUser := role == "author" || role == "other_user"
+
+	// STEP 1: Already stale
+	if analysis.IsStale {
+		if byUser {
+			actions := []RuleAction{{Tool: "remove_label_from_issue", Label: repo.StaleLabelName}}
+			if analysis.MaintainerAlertNeeded {
+				actions = append(actions, RuleAction{Tool: "alert_maintainer_of_edit"})
+			}
+			return Decision{
+				Verdict: VerdictActive,
+				Actions: actions,
+				Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. User activity detected. Removed stale label.", n),
+			}, nil
+		}
+
+		if role == "maintainer" {
+			if analysis.DaysSinceStaleLabel > repo.closeThresholdDays() {
+				return Decision{
+					Verdict: VerdictStale,
+					Actions: []RuleAction{{Tool: "close_as_stale"}},
+					Report:  fmt.Sprintf("Analysis for Issue #%d: STALE. Close threshold met. Closing.", n),
+				}, nil
+			}
+			return Decision{
+				Verdict: VerdictStale,
+				Report:  fmt.Sprintf("Analysis for Issue #%d: STALE. Waiting for close threshold. No action.", n),
+			}, nil
+		}
+	}
+
+	// STEP 2: Active, last action by a user
+	if byUser {
+		if analysis.MaintainerAlertNeeded {
+			return Decision{
+				Verdict: VerdictActive,
+				Actions: []RuleAction{{Tool: "alert_maintainer_of_edit"}},
+				Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. Silent update detected (Description Edit). Alerted maintainer.", n),
+			}, nil
+		}
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. Last action was by user. No action.", n),
+		}, nil
+	}
+
+	if role != "maintainer" {
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. Unrecognized last action role %q. No action.", n, role),
+		}, nil
+	}
+
+	// STEP 3: Analyze maintainer intent
+	var class CommentClassification
+	if analysis.State.LastCommentText != nil {
+		var err error
+		class, err = classifier.Classify(ctx, analysis)
+		if err != nil {
+			return Decision{}, fmt.Errorf("classifying maintainer comment: %w", err)
+		}
+	}
+
+	if class.InternalDiscussion {
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. Maintainer is discussing with another maintainer. No action.", n),
+		}, nil
+	}
+
+	if !class.IsQuestion {
+		return Decision{
+			Verdict: VerdictActive,
+			Report:  fmt.Sprintf("Analysis for Issue #%d: ACTIVE. Maintainer gave status update or internal discussion detected. No action.", n),
+		}, nil
+	}
+
+	if analysis.DaysSinceActivity <= repo.staleThresholdDays() {
+		return Decision{
+			Verdict: VerdictPending,
+			Report:  fmt.Sprintf("Analysis for Issue #%d: PENDING. Maintainer asked question, but threshold not met yet. No action.", n),
+		}, nil
+	}
+
+	actions := []RuleAction{{Tool: "add_stale_label_and_comment"}}
+	if !containsString(analysis.Labels, repo.RequestClarificationLabel) {
+		actions = append(actions, RuleAction{Tool: "add_label_to_issue", Label: repo.RequestClarificationLabel})
+	}
+	return Decision{
+		Verdict: VerdictStale,
+		Actions: actions,
+		Report:  fmt.Sprintf("Analysis for Issue #%d: STALE. Maintainer asked question %.1f days ago. Marking stale.", n, analysis.DaysSinceActivity),
+	}, nil
+}
+
+// applyDecision executes the chosen actions through the same tool functions
+// the agent uses.
+func applyDecision(ctx context.Context, repo *Repository, issueNumber int, decision Decision) error {
+	for _, action := range decision.Actions {
+		var res ToolResult
+		var err error
+
+		switch action.Tool {
+		case "add_label_to_issue":
+			res, err = repo.addLabelToIssue(ctx, LabelTargetArgs{IssueNumber: issueNumber, LabelName: action.Label})
+		case "remove_label_from_issue":
+			res, err = repo.removeLabelFromIssue(ctx, LabelTargetArgs{IssueNumber: issueNumber, LabelName: action.Label})
+		case "add_stale_label_and_comment":
+			res, err = repo.addStaleLabelAndComment(ctx, IssueTargetArgs{IssueNumber: issueNumber})
+		case "alert_maintainer_of_edit":
+			res, err = repo.alertMaintainerOfEdit(ctx, IssueTargetArgs{IssueNumber: issueNumber})
+		case "close_as_stale":
+			res, err = repo.closeAsStale(ctx, IssueTargetArgs{IssueNumber: issueNumber})
+		default:
+			return fmt.Errorf("unknown rule action %q", action.Tool)
+		}
+
+		auditFrom(ctx).recordToolCall(action.Tool, action, res, err)
+		toolInvocations.Inc(action.Tool, toolOutcome(res, err))
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", action.Tool, err)
+		}
+		if res.Status != "success" {
+			return fmt.Errorf("%s failed: %s", action.Tool, res.Message)
+		}
+		slog.InfoContext(ctx, "Applied action", "tool", action.Tool, "status", res.Status, "message", res.Message)
+	}
+	return nil
+}
+
+// runRulesForIssue evaluates and applies the decision tree for one issue.
+func runRulesForIssue(ctx context.Context, repo *Repository, issueNumber int, classifier CommentClassifier) (Decision, error) {
+	analysis, err := repo.analyzeIssue(ctx, issueNumber)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	auditFrom(ctx).setState(analysis.toMap())
+
+	decision, err := evaluateIssue(ctx, analysis, classifier)
+	if err != nil {
+		return Decision{}, err
+	}
+	auditFrom(ctx).setDecision(decision.Verdict, decision.Report)
+
+	if err := applyDecision(ctx, repo, issueNumber, decision); err != nil {
+		return decision, err
+	}
+	return decision, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------- Heuristic Classifier ----------------
+
+// questionPattern matches phrases that ask the author for something. A
+// comment only mentioning logs or a reproduction ("the logs show it is
+// fixed") is a statement, not a question.
+var questionPattern = regexp.MustCompile(`(?i)\b(` + strings.Join([]string{
+	`could you`, `can you`, `would you`, `do you have`, `are you (still|able)`,
+	`is this still`, `please (provide|share|try|attach|confirm|clarify|send|post|check)`,
+	`let (us|me) know`, `any updates?`,
+}, `|`) + `)\b`)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9-]+)`)
+
+// heuristicClassifier classifies maintainer comments with keyword and
+// @mention matching, without calling a model.
+type heuristicClassifier struct{}
+
+func (heuristicClassifier) Classify(ctx context.Context, analysis *IssueAnalysis) (CommentClassification, error) {
+	text := ""
+	if analysis.State.LastCommentText != nil {
+		text = *analysis.State.LastCommentText
+	}
+
+	var class CommentClassification
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		if m[1] != analysis.State.LastActorName && isMaintainer(m[1], analysis.Maintainers) {
+			class.InternalDiscussion = true
+		}
+	}
+
+	if strings.Contains(text, "?") || questionPattern.MatchString(text) {
+		class.IsQuestion = true
+	}
+	return class, nil
+}
+
+// ---------------- Model Classifier ----------------
+
+const classifierPrompt = `You review the last comment a maintainer left on a GitHub issue.
+
+Maintainers: %s
+Comment author: %s
+Comment:
+"""
+%s
+"""
+
+Answer two questions:
+1. is_question: does the comment ask a question, request clarification, ask for logs, or give suggestions for the author to try?
+2. internal_discussion: does the comment mention or address any maintainer from the list other than the comment author?
+
+Respond with only a JSON object: {"is_question": true|false, "internal_discussion": true|false}`
+
+// modelClassifier asks the configured model to classify the comment. It is a
+// single stateless call, much cheaper than a full agent conversation.
+type modelClassifier struct {
+	llm model.LLM
+}
+
+func (c modelClassifier) Classify(ctx context.Context, analysis *IssueAnalysis) (CommentClassification, error) {
+	text := ""
+	if analysis.State.LastCommentText != nil {
+		text = *analysis.State.LastCommentText
+	}
+
+	prompt := fmt.Sprintf(
+		classifierPrompt,
+		strings.Join(analysis.Maintainers, ", "),
+		analysis.State.LastActorName,
+		text,
+	)
+
+	req := &model.LLMRequest{
+		Model: c.llm.Name(),
+		Contents: []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{{Text: prompt}}},
+		},
+		Config: &genai.GenerateContentConfig{
+			ResponseMIMEType: "application/json",
+		},
+	}
+
+	var out strings.Builder
+	for resp, err := range c.llm.GenerateContent(ctx, req, false) {
+		if err != nil {
+			return CommentClassification{}, err
+		}
+		if resp.Content == nil {
+			continue
+		}
+		for _, part := range resp.Content.Parts {
+			out.WriteString(part.Text)
+		}
+	}
+
+	raw := strings.TrimSpace(out.String())
+	raw = strings.TrimPrefix(raw, "```json")
+	raw = strings.TrimPrefix(raw, "```")
+	raw = strings.TrimSuffix(raw, "```")
+
+	var class CommentClassification
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &class); err != nil {
+		return CommentClassification{}, fmt.Errorf("invalid classifier response %q: %w", raw, err)
+	}
+	return class, nil
+}
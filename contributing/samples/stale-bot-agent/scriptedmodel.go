@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptedModel is a model.LLM that replays fixed responses instead of
+// calling Gemini, so the agent path (PROMPT_INSTRUCTION.txt plus the tools)
+// can run offline and deterministically. Agent turns are looked up by the
+// issue number in the "Audit Issue #N." prompt and by how many model turns
+// the conversation already has; classifier calls are matched on the
+// comment text. It holds no state, so concurrent issues are safe.
+type ScriptedModel struct {
+	Script ModelScript
+}
+
+// ModelScript is the fixture format, either a model_script file or the
+// model section of a test scenario.
+type ModelScript struct {
+	// Issues maps an issue number to the agent's turns, in order.
+	Issues map[int][]ScriptedTurn `yaml:"issues"`
+
+	// Classifications answer the rules engine's model classifier; the first
+	// entry whose comment_contains is in the comment wins.
+	Classifications []ScriptedClassification `yaml:"classifications"`
+}
+
+// ScriptedTurn is one model response: tool calls, final text, or both.
+type ScriptedTurn struct {
+	ToolCalls []ScriptedToolCall `yaml:"tool_calls"`
+	Text      string             `yaml:"text"`
+}
+
+type ScriptedToolCall struct {
+	Name string         `yaml:"name"`
+	Args map[string]any `yaml:"args"`
+}
+
+type ScriptedClassification struct {
+	CommentContains    string `yaml:"comment_contains"`
+	IsQuestion         bool   `yaml:"is_question"`
+	InternalDiscussion bool   `yaml:"internal_discussion"`
+}
+
+// LoadScriptedModel reads a ModelScript fixture.
+func LoadScriptedModel(path string) (*ScriptedModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var script ModelScript
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&script); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &ScriptedModel{Script: script}, nil
+}
+
+func (m *ScriptedModel) Name() string {
+	return "scripted"
+}
+
+var auditPromptPattern = regexp.MustCompile(`Audit Issue #(\d+)\.`)
+
+func (m *ScriptedModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		content, err := m.respond(req)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(&model.LLMResponse{Content: content, TurnComplete: true}, nil)
+	}
+}
+
+func (m *ScriptedModel) respond(req *model.LLMRequest) (*genai.Content, error) {
+	issueNumber, turn := 0, 0
+	var prompt string
+	for _, c := range req.Contents {
+		if c == nil {
+			continue
+		}
+		if c.Role == genai.RoleModel {
+			turn++
+			continue
+		}
+		for _, p := range c.Parts {
+			if p == nil || p.Text == "" {
+				continue
+			}
+			if prompt == "" {
+				prompt = p.Text
+			}
+			if issueNumber == 0 {
+				if match := auditPromptPattern.FindStringSubmatch(p.Text); match != nil {
+					issueNumber, _ = strconv.Atoi(match[1])
+				}
+			}
+		}
+	}
+
+	if issueNumber == 0 {
+		return m.classify(prompt)
+	}
+
+	turns, ok := m.Script.Issues[issueNumber]
+	if !ok {
+		return nil, fmt.Errorf("scripted model: no script for issue #%d", issueNumber)
+	}
+	if turn >= len(turns) {
+		return nil, fmt.Errorf("scripted model: script for issue #%d has %d turns, turn %d requested", issueNumber, len(turns), turn+1)
+	}
+
+	scripted := turns[turn]
+	content := &genai.Content{Role: genai.RoleModel}
+	if scripted.Text != "" {
+		content.Parts = append(content.Parts, &genai.Part{Text: scripted.Text})
+	}
+	for i, call := range scripted.ToolCalls {
+		content.Parts = append(content.Parts, &genai.Part{FunctionCall: &genai.FunctionCall{
+			ID:   fmt.Sprintf("scripted-%d-%d-%d", issueNumber, turn, i),
+			Name: call.Name,
+			Args: call.Args,
+		}})
+	}
+	return content, nil
+}
+
+// classify answers a classifierPrompt request from the comment between the
+// triple quotes.
+func (m *ScriptedModel) classify(prompt string) (*genai.Content, error) {
+	_, comment, found := strings.Cut(prompt, `"""`)
+	comment, _, _ = strings.Cut(comment, `"""`)
+	if !found {
+		return nil, fmt.Errorf("scripted model: unrecognized request %.80q", prompt)
+	}
+
+	for _, c := range m.Script.Classifications {
+		if strings.Contains(comment, c.CommentContains) {
+			text := fmt.Sprintf(`{"is_question": %t, "internal_discussion": %t}`, c.IsQuestion, c.InternalDiscussion)
+			return &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{{Text: text}}}, nil
+		}
+	}
+	return nil, fmt.Errorf("scripted model: no classification matches comment %.80q", strings.TrimSpace(comment))
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -46,10 +52,14 @@ func ResetAPICallCount() {
 	apiCallCount = 0
 }
 
-func incrementAPICallCount() {
+// incrementAPICallCount counts one request for the run and for the issue
+// being processed in ctx, if any.
+func incrementAPICallCount(ctx context.Context) {
 	counterLock.Lock()
 	apiCallCount++
 	counterLock.Unlock()
+
+	auditFrom(ctx).countAPICall()
 }
 
 // ---------------- HTTP Client ----------------
@@ -58,21 +68,146 @@ var httpClient = &http.Client{
 	Timeout: 60 * time.Second,
 }
 
+// configureHTTPClient installs a transport that trusts the PEM certificates
+// in caBundle in addition to the system roots, and sends requests through
+// proxy. With an empty proxy the HTTPS_PROXY/NO_PROXY variables apply.
+func configureHTTPClient(caBundle, proxy string) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	httpClient.Transport = transport
+	return nil
+}
+
+// authorizationHeader renders the Authorization header for a request: an
+// installation token of the repository the request is for when running as a
+// GitHub App, GitHubToken otherwise.
+func authorizationHeader(req *http.Request) (string, error) {
+	token := GitHubToken
+	if gitHubApp != nil {
+		var err error
+		token, err = gitHubApp.InstallationToken(req.Context(), requestRepository(req))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if GitHubAuthScheme == "bearer" {
+		return "Bearer " + token, nil
+	}
+	return "token " + token, nil
+}
+
 // ---------------- Core HTTP Logic ----------------
 
+// errAlreadyApplied is returned by a retry guard when an earlier attempt
+// turns out to have succeeded server-side even though its response was lost.
+var errAlreadyApplied = errors.New("request already applied by an earlier attempt")
+
 func doRequest(req *http.Request) (*http.Response, error) {
-	req.Header.Set("Authorization", "token "+GitHubToken)
+	return doRequestGuarded(req, nil)
+}
+
+// doRequestGuarded sends req with retries. Request bodies are rewound through
+// req.GetBody before every retry, so POST and PATCH payloads are resent in
+// full. When beforeRetry is set it runs before each retry and can report that
+// the previous attempt was applied after all, which ends the retry loop with
+// errAlreadyApplied.
+func doRequestGuarded(req *http.Request, beforeRetry func() (bool, error)) (*http.Response, error) {
+	// Requests that arrive with credentials (app JWT calls) keep them.
+	presetAuth := req.Header.Get("Authorization") != ""
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
+	if req.Body != nil && req.GetBody == nil {
+		return nil, fmt.Errorf("request body for %s %s cannot be replayed on retry", req.Method, req.URL.Path)
+	}
+
 	var resp *http.Response
 	var err error
 	backoff := time.Second
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			githubRetries.Inc(req.Method, metricsEndpoint(req.URL))
+			if beforeRetry != nil {
+				applied, guardErr := beforeRetry()
+				if guardErr != nil {
+					return nil, fmt.Errorf("checking whether %s %s was applied: %w", req.Method, req.URL.Path, guardErr)
+				}
+				if applied {
+					return nil, errAlreadyApplied
+				}
+			}
+			if req.GetBody != nil {
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					return nil, bodyErr
+				}
+				req.Body = body
+			}
+		}
+
+		if wait := rateLimitWait(req); wait > 0 {
+			if wait > maxRateLimitWait {
+				return nil, fmt.Errorf("rate limit exhausted for %s, resets in %s", req.URL.Path, wait.Round(time.Second))
+			}
+			slog.WarnContext(req.Context(), "Rate limit exhausted, sleeping until reset", "wait", wait.Round(time.Second).String())
+			if err := sleepCtx(req.Context(), wait); err != nil {
+				return nil, err
+			}
+		}
+
+		// Every attempt, retries included, draws from the shared budget.
+		if err := githubLimiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		// Set per attempt: an installation token may be refreshed while a
+		// retry waits.
+		if !presetAuth {
+			auth, authErr := authorizationHeader(req)
+			if authErr != nil {
+				return nil, fmt.Errorf("authenticating %s %s: %w", req.Method, req.URL.Path, authErr)
+			}
+			req.Header.Set("Authorization", auth)
+		}
+
 		resp, err = httpClient.Do(req)
 
-		if err == nil && !retryStatusCodes[resp.StatusCode] {
-			return resp, nil
+		if err != nil {
+			recordGitHubRequest(req, nil)
+		} else {
+			recordGitHubRequest(req, resp)
+			updateRateLimit(req, resp)
+			retryable := retryStatusCodes[resp.StatusCode] || isRateLimited(resp)
+			if !retryable || attempt == maxRetries {
+				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+					return nil, newAPIError(req, resp)
+				}
+				return resp, nil
+			}
 		}
 
 		if resp != nil {
@@ -83,7 +218,23 @@ func doRequest(req *http.Request) (*http.Response, error) {
 			break
 		}
 
-		time.Sleep(backoff)
+		var delayResp *http.Response
+		if err == nil {
+			delayResp = resp
+		}
+		delay := retryDelay(delayResp, backoff)
+		if delay > maxRateLimitWait {
+			return nil, fmt.Errorf("rate limited on %s, retry after %s", req.URL.Path, delay.Round(time.Second))
+		}
+		if err == nil {
+			slog.WarnContext(req.Context(), "GitHub request failed, retrying",
+				"method", req.Method, "path", req.URL.Path, "status", resp.StatusCode,
+				"delay", delay.Round(time.Millisecond).String(), "attempt", attempt+1, "max_retries", maxRetries)
+		}
+
+		if err := sleepCtx(req.Context(), delay); err != nil {
+			return nil, err
+		}
 		backoff *= backoffFactor
 	}
 
@@ -94,10 +245,23 @@ func doRequest(req *http.Request) (*http.Response, error) {
 	return nil, fmt.Errorf("request failed after retries")
 }
 
+// sleepCtx waits for d, returning early with the context's error when ctx
+// is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 // ---------------- Public Request Helpers ----------------
 
-func GetRequest(rawURL string, params map[string]any) (any, error) {
-	incrementAPICallCount()
+func GetRequest(ctx context.Context, rawURL string, params map[string]any) (any, error) {
+	incrementAPICallCount(ctx)
 
 	u, err := url.Parse(rawURL)
 	if err != nil {
@@ -112,14 +276,14 @@ func GetRequest(rawURL string, params map[string]any) (any, error) {
 		u.RawQuery = q.Encode()
 	}
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	resp, err := doRequest(req)
 	if err != nil {
-		log.Printf("GET request failed for %s: %v", rawURL, err)
+		slog.WarnContext(ctx, "GET request failed", "url", rawURL, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -127,18 +291,73 @@ func GetRequest(rawURL string, params map[string]any) (any, error) {
 	return decodeJSON(resp)
 }
 
-func PostRequest(url string, payload any) (any, error) {
-	incrementAPICallCount()
+func PostRequest(ctx context.Context, url string, payload any) (any, error) {
+	incrementAPICallCount(ctx)
 
 	body, _ := json.Marshal(payload)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
 	if err != nil {
+		slog.WarnContext(ctx, "POST request failed", "url", url, "error", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	return decodeJSON(resp)
+}
+
+// PostJSONRequest sends a POST and decodes the JSON response into out.
+func PostJSONRequest(ctx context.Context, url string, payload any, out any) error {
+	incrementAPICallCount(ctx)
+
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 
 	resp, err := doRequest(req)
 	if err != nil {
-		log.Printf("POST request failed for %s: %v", url, err)
+		slog.WarnContext(ctx, "POST request failed", "url", url, "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// PostCommentRequest posts an issue comment to commentsURL. A comment POST
+// that times out or gets a 5xx may still have been created, so before every
+// retry the issue's recent comments are checked for an identical body and
+// the retry is skipped if it is already there.
+func PostCommentRequest(ctx context.Context, commentsURL, comment string) (any, error) {
+	incrementAPICallCount(ctx)
+
+	body, _ := json.Marshal(map[string]string{"body": comment})
+	req, err := http.NewRequestWithContext(ctx, "POST", commentsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	since := clock().UTC().Add(-time.Minute)
+	guard := func() (bool, error) {
+		return commentExists(ctx, commentsURL, comment, since)
+	}
+
+	resp, err := doRequestGuarded(req, guard)
+	if errors.Is(err, errAlreadyApplied) {
+		slog.InfoContext(ctx, "Comment was already created by an earlier attempt, not posting again", "url", commentsURL)
+		return map[string]any{
+			"status":  "success",
+			"message": "Comment already posted.",
+		}, nil
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "POST request failed", "url", commentsURL, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -146,18 +365,43 @@ func PostRequest(url string, payload any) (any, error) {
 	return decodeJSON(resp)
 }
 
-func PatchRequest(url string, payload any) (any, error) {
-	incrementAPICallCount()
+// commentExists reports whether a comment with exactly this body was
+// created on the issue since the given time.
+func commentExists(ctx context.Context, commentsURL, comment string, since time.Time) (bool, error) {
+	dataAny, err := GetRequest(ctx, commentsURL, map[string]any{
+		"since":    since.Format(time.RFC3339),
+		"per_page": 100,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	comments, ok := dataAny.([]any)
+	if !ok {
+		return false, fmt.Errorf("invalid comments response format: %T", dataAny)
+	}
+	for _, c := range comments {
+		if m, ok := c.(map[string]any); ok {
+			if existing, _ := m["body"].(string); strings.TrimSpace(existing) == strings.TrimSpace(comment) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func PatchRequest(ctx context.Context, url string, payload any) (any, error) {
+	incrementAPICallCount(ctx)
 
 	body, _ := json.Marshal(payload)
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
 	resp, err := doRequest(req)
 	if err != nil {
-		log.Printf("PATCH request failed for %s: %v", url, err)
+		slog.WarnContext(ctx, "PATCH request failed", "url", url, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -165,17 +409,17 @@ func PatchRequest(url string, payload any) (any, error) {
 	return decodeJSON(resp)
 }
 
-func DeleteRequest(url string) (any, error) {
-	incrementAPICallCount()
+func DeleteRequest(ctx context.Context, url string) (any, error) {
+	incrementAPICallCount(ctx)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	resp, err := doRequest(req)
 	if err != nil {
-		log.Printf("DELETE request failed for %s: %v", url, err)
+		slog.WarnContext(ctx, "DELETE request failed", "url", url, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -208,23 +452,34 @@ func decodeJSON(resp *http.Response) (any, error) {
 
 // ---------------- Issue Search ----------------
 
-func GetOldOpenIssueNumbers(owner, repo string, daysOld *float64) ([]int, error) {
-	days := STALE_HOURS_THRESHOLD / 24
+func GetOldOpenIssueNumbers(ctx context.Context, repo *Repository, daysOld *float64) ([]int, error) {
+	days := repo.staleThresholdDays()
 	if daysOld != nil {
 		days = *daysOld
 	}
+	return searchOldOpenItems(ctx, repo, "issue", days)
+}
 
-	cutoff := time.Now().UTC().
+// GetOldOpenPullRequestNumbers returns the open pull requests created before
+// the pull request stale threshold.
+func GetOldOpenPullRequestNumbers(ctx context.Context, repo *Repository) ([]int, error) {
+	return searchOldOpenItems(ctx, repo, "pr", PRStaleHoursThreshold/24.0)
+}
+
+// searchOldOpenItems pages the Search API for open items of kind ("issue" or
+// "pr") created more than days ago.
+func searchOldOpenItems(ctx context.Context, repo *Repository, kind string, days float64) ([]int, error) {
+	cutoff := clock().UTC().
 		Add(-time.Duration(days*24) * time.Hour).
 		Format("2006-01-02T15:04:05Z")
 
 	query := fmt.Sprintf(
-		"repo:%s/%s is:issue state:open created:<%s",
-		owner, repo, cutoff,
+		"repo:%s is:%s state:open created:<%s",
+		repo.FullName(), kind, cutoff,
 	)
 
-	log.Printf("SEARCH QUERY: %s", query)
-	log.Printf("Searching for issues created before %s...", cutoff)
+	slog.DebugContext(ctx, "Search query", "query", query)
+	slog.InfoContext(ctx, "Searching for old open items", "kind", kind, "created_before", cutoff)
 
 	var issueNumbers []int
 	page := 1
@@ -237,17 +492,21 @@ func GetOldOpenIssueNumbers(owner, repo string, daysOld *float64) ([]int, error)
 		}
 
 		dataAny, err := GetRequest(
-			"https://api.github.com/search/issues",
+			ctx,
+			GitHubBaseURL+"/search/issues",
 			params,
 		)
 		if err != nil {
-			log.Printf("GitHub search failed on page %d: %v", page, err)
+			slog.WarnContext(ctx, "GitHub search failed", "page", page, "error", err)
+			if ctx.Err() != nil {
+				return issueNumbers, ctx.Err()
+			}
 			break
 		}
 
 		data, ok := dataAny.(map[string]any)
 		if !ok {
-			log.Printf("Invalid API response format")
+			slog.WarnContext(ctx, "Invalid search response format", "page", page)
 			break
 		}
 
@@ -258,7 +517,7 @@ func GetOldOpenIssueNumbers(owner, repo string, daysOld *float64) ([]int, error)
 
 		for _, item := range items {
 			m := item.(map[string]any)
-			if _, isPR := m["pull_request"]; !isPR {
+			if _, isPR := m["pull_request"]; isPR == (kind == "pr") {
 				if n, ok := m["number"].(float64); ok {
 					issueNumbers = append(issueNumbers, int(n))
 				}
@@ -271,6 +530,6 @@ func GetOldOpenIssueNumbers(owner, repo string, daysOld *float64) ([]int, error)
 		page++
 	}
 
-	log.Printf("Found %d stale issues.", len(issueNumbers))
+	slog.InfoContext(ctx, "Found old open items", "kind", kind, "count", len(issueNumbers))
 	return issueNumbers, nil
 }